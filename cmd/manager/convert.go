@@ -0,0 +1,129 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/flanksource/platform-operator/pkg/openshift"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+const convertOpenshiftQuotaCommand = "convert-openshift-quota"
+
+type manifest struct {
+	metav1.TypeMeta `json:",inline"`
+	Items           []json.RawMessage `json:"items"`
+}
+
+// convertOpenshiftQuota reads quota.openshift.io/v1 ClusterResourceQuota manifests (or lists of them) and
+// writes the platform.flanksource.com/v1 equivalents to stdout, quotas that cannot be converted are reported
+// on stderr and cause a non-zero exit code
+func convertOpenshiftQuota(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet(convertOpenshiftQuotaCommand, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	file := flags.String("f", "-", "File containing OpenShift ClusterResourceQuota manifests, - for stdin")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	in := stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+
+	objects := []json.RawMessage{}
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(in))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		data, err := yaml.YAMLToJSON(doc)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		if string(data) == "null" {
+			continue
+		}
+		m := manifest{}
+		if err := json.Unmarshal(data, &m); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		if m.Kind == "List" || m.Kind == openshift.Kind+"List" {
+			objects = append(objects, m.Items...)
+		} else {
+			objects = append(objects, data)
+		}
+	}
+
+	failed := 0
+	converted := 0
+	for _, data := range objects {
+		quota := &openshift.ClusterResourceQuota{}
+		if err := json.Unmarshal(data, quota); err != nil {
+			fmt.Fprintln(stderr, err)
+			failed++
+			continue
+		}
+		if quota.APIVersion != openshift.APIVersion || quota.Kind != openshift.Kind {
+			fmt.Fprintf(stderr, "skipping %s/%s %s: not an OpenShift ClusterResourceQuota\n", quota.APIVersion, quota.Kind, quota.Name)
+			continue
+		}
+		out, err := openshift.ToPlatform(quota)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			failed++
+			continue
+		}
+		data, err := yaml.Marshal(out)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			failed++
+			continue
+		}
+		if converted > 0 {
+			fmt.Fprintln(stdout, "---")
+		}
+		fmt.Fprint(stdout, string(data))
+		converted++
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(stderr, "%d ClusterResourceQuota(s) could not be converted\n", failed)
+		return 1
+	}
+	return 0
+}
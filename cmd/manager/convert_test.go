@@ -0,0 +1,150 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestConvertOpenshiftQuota(t *testing.T) {
+	const converted = `apiVersion: platform.flanksource.com/v1
+kind: ClusterResourceQuota
+metadata:
+  annotations:
+    cost-center: "42"
+  creationTimestamp: null
+  name: team-a
+spec:
+  hard:
+    pods: "10"
+  matchLabels:
+    team: a
+status:
+  total: {}
+`
+	const quota = `apiVersion: quota.openshift.io/v1
+kind: ClusterResourceQuota
+metadata:
+  name: team-a
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: "{}"
+    openshift.io/requester: alice
+    cost-center: "42"
+spec:
+  selector:
+    labels:
+      matchLabels:
+        team: a
+  quota:
+    hard:
+      pods: "10"
+`
+	fixtures := []struct {
+		name   string
+		in     string
+		out    string
+		stderr string
+		code   int
+	}{
+		{
+			name: "quota",
+			in:   quota,
+			out:  converted,
+		},
+		{
+			name: "list",
+			in: `apiVersion: v1
+kind: List
+items:
+- ` + strings.ReplaceAll(strings.TrimSpace(quota), "\n", "\n  ") + `
+- ` + strings.ReplaceAll(strings.TrimSpace(quota), "\n", "\n  "),
+			out: converted + "---\n" + converted,
+		},
+		{
+			name: "all namespaces",
+			in: `apiVersion: quota.openshift.io/v1
+kind: ClusterResourceQuota
+metadata:
+  name: everyone
+spec:
+  selector:
+    labels: {}
+  quota:
+    hard:
+      pods: "10"
+`,
+			out: `apiVersion: platform.flanksource.com/v1
+kind: ClusterResourceQuota
+metadata:
+  creationTimestamp: null
+  name: everyone
+spec:
+  allNamespaces: true
+  hard:
+    pods: "10"
+status:
+  total: {}
+`,
+		},
+		{
+			name:   "other kinds",
+			in:     "apiVersion: v1\nkind: ResourceQuota\nmetadata:\n  name: rq\n---\n" + quota,
+			out:    converted,
+			stderr: "skipping v1/ResourceQuota rq: not an OpenShift ClusterResourceQuota\n",
+		},
+		{
+			name: "unsupported selector",
+			in: `apiVersion: quota.openshift.io/v1
+kind: ClusterResourceQuota
+metadata:
+  name: annotated
+spec:
+  selector:
+    annotations:
+      openshift.io/requester: alice
+  quota:
+    hard:
+      pods: "10"
+`,
+			stderr: "ClusterResourceQuota/annotated cannot be converted: spec.selector.annotations openshift.io/requester=alice\n" +
+				"1 ClusterResourceQuota(s) could not be converted\n",
+			code: 1,
+		},
+		{
+			name: "empty",
+			in:   "",
+		},
+	}
+
+	for _, fixture := range fixtures {
+		t.Run(fixture.name, func(t *testing.T) {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			code := convertOpenshiftQuota(nil, strings.NewReader(fixture.in), stdout, stderr)
+			if code != fixture.code {
+				t.Errorf("expected exit code %d, got %d", fixture.code, code)
+			}
+			if stdout.String() != fixture.out {
+				t.Errorf("expected output:\n%s\ngot:\n%s", fixture.out, stdout.String())
+			}
+			if stderr.String() != fixture.stderr {
+				t.Errorf("expected errors:\n%s\ngot:\n%s", fixture.stderr, stderr.String())
+			}
+		})
+	}
+}
@@ -17,7 +17,9 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
@@ -26,17 +28,25 @@ import (
 	"time"
 
 	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	platformv2 "github.com/flanksource/platform-operator/pkg/apis/platform/v2"
+	"github.com/flanksource/platform-operator/pkg/controllers/cabundle"
 	"github.com/flanksource/platform-operator/pkg/controllers/cleanup"
 	"github.com/flanksource/platform-operator/pkg/controllers/clusterresourcequota"
 	"github.com/flanksource/platform-operator/pkg/controllers/ingress"
+	"github.com/flanksource/platform-operator/pkg/controllers/inheritance"
 	"github.com/flanksource/platform-operator/pkg/controllers/pod"
+	"github.com/flanksource/platform-operator/pkg/controllers/pullsecret"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/conversion"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -48,25 +58,53 @@ var (
 func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
 
+	_ = apiextensionsv1.AddToScheme(scheme)
 	_ = platformv1.AddToScheme(scheme)
+	_ = platformv2.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == convertOpenshiftQuotaCommand {
+		os.Exit(convertOpenshiftQuota(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
 
 	var cleanupInterval, annotationInterval time.Duration
 	var enableClusterResourceQuota bool
+	var quotaExportSink, quotaExportFormat string
+	var quotaExportInterval time.Duration
+	var quotaExportHistory int
 	var ingressSSO bool
 	var oauth2ProxySvcName string
 	var oauth2ProxySvcNamespace string
 	var domain string
 
 	var registryWhitelist string
+	var registryMirrors string
+	var registryPullSecrets string
+	var nodeSelectorWhitelist string
+	var defaultRequests, defaultLimits, maxLimitRequestRatio string
 	var annotations string
+	var inheritLabels, inheritLabelsKinds string
+	var namespaceParentLabel string
 	var podMutator bool
 	cfg := platformv1.PodMutaterConfig{}
+	var digestFailurePolicy string
+	var imagePolicy bool
+	var allowedRegistries string
+	imagePolicyCfg := platformv1.ImagePolicyConfig{}
+	var nodeGroupTenancy bool
+	var tenancyExemptNamespaces, tenancyNodeGroupTaints string
+	var podHardening bool
+	var hardeningExemptNamespaces string
+	hardeningCfg := platformv1.PodHardeningConfig{}
+	var replicatePullSecret string
+	pullSecretCfg := platformv1.ReplicationConfig{}
+	var injectEnv, caBundle string
+	caBundleCfg := platformv1.ReplicationConfig{}
 
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 
@@ -78,6 +116,10 @@ func main() {
 
 	flag.BoolVar(&enableClusterResourceQuota, "enable-cluster-resource-quota", true, "Enable/Disable cluster resource quota")
 
+	flag.StringVar(&quotaExportSink, "quota-export-sink", "", "Where to export ClusterResourceQuota usage snapshots: file:///path, configmap://namespace/name or http(s)://url")
+	flag.StringVar(&quotaExportFormat, "quota-export-format", "json", "Format of exported usage snapshots: json or csv")
+	flag.DurationVar(&quotaExportInterval, "quota-export-interval", time.Hour, "Frequency at which ClusterResourceQuota usage is exported.")
+	flag.IntVar(&quotaExportHistory, "quota-export-history", 24, "Number of snapshots to keep when exporting to a configmap")
 	flag.BoolVar(&ingressSSO, "enable-ingress-sso", false, "Enable ingress mutation hook for restrict-to-groups SSO")
 	flag.StringVar(&oauth2ProxySvcName, "oauth2-proxy-service-name", "", "Name of oauth2-proxy service")
 	flag.StringVar(&oauth2ProxySvcNamespace, "oauth2-proxy-service-namespace", "", "Name of oauth2-proxy service namespace")
@@ -86,19 +128,131 @@ func main() {
 	flag.BoolVar(&podMutator, "enable-pod-mutations", true, "Enable pod mutating webhooks")
 
 	flag.StringVar(&annotations, "annotations", "", "Annotations pods inherit from parent namespace")
+	flag.StringVar(&namespaceParentLabel, "namespace-parent-label", "", "A namespace label naming the parent namespace, whose annotations sub-namespaces inherit, e.g. parent")
+	flag.StringVar(&inheritLabels, "inherit-labels", "", "A list of label prefixes resources inherit from their namespace, e.g. team,cost-center")
+	flag.StringVar(&inheritLabelsKinds, "inherit-labels-kinds", "pods,persistentvolumeclaims,services,deployments,statefulsets,daemonsets,replicasets,jobs,cronjobs", "The kinds of resources inheriting the labels of their namespace, pods require --enable-pod-mutations")
 	flag.StringVar(&cfg.DefaultRegistryPrefix, "default-registry-prefix", "", "A default registry prefix path to apply to all pods")
 	flag.StringVar(&cfg.DefaultImagePullSecret, "default-image-pull-secret", "", "A default image pull secret to apply to all pods")
 	flag.StringVar(&registryWhitelist, "registry-whitelist", "", "A list of image prefixes to ignore")
+	flag.StringVar(&registryMirrors, "registry-mirrors", "", "A list of registry=mirror mappings to pull images from, e.g. docker.io=mirror.corp/dockerhub,gcr.io/*=mirror.corp/gcr")
+	flag.StringVar(&registryPullSecrets, "registry-pull-secrets", "", "A list of registry=secret mappings of the image pull secrets pods pulling from a registry need, e.g. registry.corp=corp-pull,ghcr.io=ghcr-pull")
 	flag.StringVar(&cfg.TolerationsAnnotation, "namespace-tolerations-annotation", "tolerations", "A namespace annotation that should be applied as tolerations on pods")
+	flag.StringVar(&cfg.NodeSelectorAnnotation, "namespace-node-selector-annotation", "scheduler.alpha.kubernetes.io/node-selector", "A namespace annotation with a node selector that is merged into pods")
+	flag.StringVar(&nodeSelectorWhitelist, "node-selector-whitelist", "", "A YAML file with the node selector labels pods are allowed to use per namespace")
+	flag.StringVar(&defaultRequests, "default-requests", "", "Requests of containers not setting them, e.g. cpu=100m,memory=128Mi")
+	flag.StringVar(&defaultLimits, "default-limits", "", "Limits of containers not setting them, e.g. cpu=1,memory=512Mi")
+	flag.StringVar(&maxLimitRequestRatio, "max-limit-request-ratio", "", "Maximum ratio of the limit to the request of containers, e.g. cpu=4,memory=2")
+	flag.StringVar(&cfg.SidecarTemplatesNamespace, "sidecar-templates-namespace", "", "A namespace with ConfigMap sidecar templates, used when there is no SidecarTemplate with the name")
+	flag.StringVar(&injectEnv, "inject-env", "", "A ; separated list of NAME=value environment variables injected into all containers, e.g. HTTP_PROXY=http://proxy:3128;NO_PROXY=.cluster.local,10.0.0.0/8")
+	flag.StringVar(&caBundle, "ca-bundle-configmap", "", "A <namespace>/<name> CA bundle configmap to replicate into namespaces and mount into all containers")
+	flag.StringVar(&cfg.CABundleKey, "ca-bundle-key", "ca.crt", "The key of the CA bundle in the configmap")
+	flag.StringVar(&caBundleCfg.NamespaceSelector, "ca-bundle-namespace-selector", "", "A label selector for the namespaces the CA bundle is replicated into, all namespaces when empty")
+	flag.BoolVar(&cfg.PinImageDigests, "pin-image-digests", false, "Rewrite image tags of pods to the digest they currently point to")
+	flag.DurationVar(&cfg.ImageDigestCacheTTL, "image-digest-cache-ttl", 5*time.Minute, "How long resolved image digests are cached")
+	flag.StringVar(&digestFailurePolicy, "image-digest-failure-policy", "open", "Whether pods are admitted (open) or denied (closed) when an image digest cannot be resolved")
+
+	flag.BoolVar(&imagePolicy, "enable-image-policy", false, "Enable the validating webhook for images of pods and workloads")
+	flag.StringVar(&allowedRegistries, "allowed-registries", "", "A list of registries or repositories images may be pulled from, all are allowed when empty")
+	flag.BoolVar(&imagePolicyCfg.AuditOnly, "image-policy-audit", false, "Only log and warn about image policy violations instead of denying them")
+	flag.BoolVar(&nodeGroupTenancy, "enable-node-group-tenancy", false, "Enable the validating webhook isolating the node groups of namespaces with the tolerations annotation")
+	flag.StringVar(&tenancyNodeGroupTaints, "tenancy-node-group-taints", "platform.flanksource.com/node-group,dedicated", "A list of taint keys, or key prefixes ending with /, whose tolerations in the tolerations annotation dedicate node groups to namespaces")
+	flag.StringVar(&tenancyExemptNamespaces, "tenancy-exempt-namespaces", "kube-system,kube-public,kube-node-lease", "A list of namespaces whose pods are exempt from node group tenancy")
+	flag.BoolVar(&podHardening, "enable-pod-hardening", false, "Enable the validating webhook denying privileged containers, host namespaces, hostPath volumes and added capabilities")
+	flag.StringVar(&hardeningExemptNamespaces, "pod-hardening-exempt-namespaces", "kube-system,kube-public,kube-node-lease", "A list of namespaces whose pods are exempt from pod hardening")
+	flag.BoolVar(&hardeningCfg.WarnOnly, "pod-hardening-warn-only", false, "Only log and warn about pod hardening violations instead of denying them")
+	flag.StringVar(&replicatePullSecret, "replicate-image-pull-secret", "", "A <namespace>/<name> image pull secret to replicate into namespaces, it is used as the default image pull secret unless one is set")
+	flag.StringVar(&pullSecretCfg.NamespaceSelector, "image-pull-secret-namespace-selector", "", "A label selector for the namespaces the image pull secret is replicated into, all namespaces when empty")
 	flag.Parse()
 
 	cfg.Annotations = strings.Split(annotations, ",")
 	cfg.RegistryWhitelist = strings.Split(registryWhitelist, ",")
+	imagePolicyCfg.AllowedRegistries = strings.Split(allowedRegistries, ",")
 
 	ctrl.SetLogger(zap.New(func(o *zap.Options) {
 		o.Development = true
 	}))
 
+	mirrors, err := pod.ParseRegistryMirrors(registryMirrors)
+	if err != nil {
+		setupLog.Error(err, "invalid --registry-mirrors")
+		os.Exit(1)
+	}
+	cfg.RegistryMirrors = mirrors
+
+	pullSecrets, err := pod.ParseRegistryPullSecrets(registryPullSecrets)
+	if err != nil {
+		setupLog.Error(err, "invalid --registry-pull-secrets")
+		os.Exit(1)
+	}
+	cfg.RegistryPullSecrets = pullSecrets
+
+	inheritanceCfg := platformv1.InheritanceConfig{}
+	if inheritLabels != "" {
+		inheritanceCfg.Labels = strings.Split(inheritLabels, ",")
+		if inheritanceCfg.Kinds, err = inheritance.ParseKinds(inheritLabelsKinds); err != nil {
+			setupLog.Error(err, "invalid --inherit-labels-kinds")
+			os.Exit(1)
+		}
+		for _, kind := range inheritanceCfg.Kinds {
+			if kind == "pods" {
+				cfg.Labels = inheritanceCfg.Labels
+			}
+		}
+	}
+
+	if cfg.DefaultRequests, err = pod.ParseResourceList(defaultRequests); err != nil {
+		setupLog.Error(err, "invalid --default-requests")
+		os.Exit(1)
+	}
+	if cfg.DefaultLimits, err = pod.ParseResourceList(defaultLimits); err != nil {
+		setupLog.Error(err, "invalid --default-limits")
+		os.Exit(1)
+	}
+	if cfg.MaxLimitRequestRatio, err = pod.ParseResourceList(maxLimitRequestRatio); err != nil {
+		setupLog.Error(err, "invalid --max-limit-request-ratio")
+		os.Exit(1)
+	}
+
+	if cfg.Env, err = pod.ParseEnv(injectEnv); err != nil {
+		setupLog.Error(err, "invalid --inject-env")
+		os.Exit(1)
+	}
+
+	if caBundle != "" {
+		parts := strings.Split(caBundle, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			setupLog.Error(fmt.Errorf("expected <namespace>/<name>, got %s", caBundle), "invalid --ca-bundle-configmap")
+			os.Exit(1)
+		}
+		caBundleCfg.SourceNamespace, caBundleCfg.SourceName = parts[0], parts[1]
+		cfg.CABundleConfigMap = caBundleCfg.SourceName
+	}
+
+	if cfg.NodeSelectorWhitelist, err = pod.LoadNodeSelectorWhitelist(nodeSelectorWhitelist); err != nil {
+		setupLog.Error(err, "invalid --node-selector-whitelist")
+		os.Exit(1)
+	}
+
+	if digestFailurePolicy != "open" && digestFailurePolicy != "closed" {
+		setupLog.Error(fmt.Errorf("expected open or closed, got %s", digestFailurePolicy), "invalid --image-digest-failure-policy")
+		os.Exit(1)
+	}
+	cfg.ImageDigestFailClosed = digestFailurePolicy == "closed"
+
+	if replicatePullSecret != "" {
+		parts := strings.Split(replicatePullSecret, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			setupLog.Error(fmt.Errorf("expected <namespace>/<name>, got %s", replicatePullSecret), "invalid --replicate-image-pull-secret")
+			os.Exit(1)
+		}
+		pullSecretCfg.SourceNamespace, pullSecretCfg.SourceName = parts[0], parts[1]
+		if cfg.DefaultImagePullSecret == "" {
+			cfg.DefaultImagePullSecret = pullSecretCfg.SourceName
+		}
+		// only reference image pull secrets once they have been replicated into the namespace of the pod
+		cfg.VerifyImagePullSecrets = true
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:             scheme,
 		MetricsBindAddress: metricsAddr,
@@ -127,17 +281,74 @@ func main() {
 			setupLog.Error(err, "unable to create controller", "controller", "ClusterResourceQuota")
 			os.Exit(1)
 		}
-		hookServer.Register("/validate-clusterresourcequota-v1", clusterresourcequota.NewClusterResourceQuotaValidatingWebhook(mgr.GetClient(), mtx, enableClusterResourceQuota))
-		hookServer.Register("/validate-resourcequota-v1", clusterresourcequota.NewResourceQuotaValidatingWebhook(mgr.GetClient(), mtx, enableClusterResourceQuota))
+	}
+
+	// the ClusterResourceQuota CRD always converts between versions through the webhook
+	hookServer.Register("/convert", &conversion.Webhook{})
+	if err := clusterresourcequota.AddStorageVersionMigration(mgr); err != nil {
+		setupLog.Error(err, "unable to create storage version migration", "resource", "ClusterResourceQuota")
+		os.Exit(1)
+	}
+
+	if quotaExportSink != "" {
+		sink, err := clusterresourcequota.NewUsageSink(mgr.GetClient(), mgr.GetAPIReader(), quotaExportSink, quotaExportFormat, quotaExportHistory)
+		if err != nil {
+			setupLog.Error(err, "invalid --quota-export-sink")
+			os.Exit(1)
+		}
+		if err := clusterresourcequota.AddUsageExporter(mgr, quotaExportInterval, sink); err != nil {
+			setupLog.Error(err, "unable to create usage exporter", "resource", "ClusterResourceQuota")
+			os.Exit(1)
+		}
+	}
+
+	if replicatePullSecret != "" {
+		if err := pullsecret.Add(mgr, pullSecretCfg); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "PullSecret")
+			os.Exit(1)
+		}
+	}
 
+	if caBundle != "" {
+		if err := cabundle.Add(mgr, caBundleCfg); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "CABundle")
+			os.Exit(1)
+		}
 	}
 
 	if podMutator {
-		if err := pod.Add(mgr, annotationInterval, cfg); err != nil {
+		hierarchy := pod.NewNamespaceHierarchy(mgr.GetClient(), namespaceParentLabel, cfg)
+		if err := hierarchy.InvalidateOnChange(context.Background(), mgr.GetCache()); err != nil {
+			setupLog.Error(err, "unable to watch namespaces", "controller", "PodAnnotator")
+			os.Exit(1)
+		}
+		if err := pod.Add(mgr, annotationInterval, cfg, hierarchy); err != nil {
 			setupLog.Error(err, "unable to create controller", "controller", "PodAnnotator")
 			os.Exit(1)
 		}
-		hookServer.Register("/mutate-v1-pod", &webhook.Admission{Handler: pod.NewMutatingWebhook(mgr.GetClient(), cfg)})
+		hookServer.Register("/mutate-v1-pod", &webhook.Admission{Handler: pod.NewMutatingWebhook(mgr.GetClient(), mgr.GetAPIReader(), mgr.GetEventRecorderFor("pod-mutator"), cfg, hierarchy)})
+	}
+
+	if len(inheritanceCfg.Labels) > 0 {
+		if err := inheritance.Add(mgr, annotationInterval, inheritanceCfg); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "LabelInheritance")
+			os.Exit(1)
+		}
+		hookServer.Register("/mutate-inherited-labels", inheritance.NewMutatingWebhook(mgr.GetClient(), inheritanceCfg))
+	}
+
+	imagePolicyCfg.Enabled = imagePolicy
+	tenancyCfg := platformv1.TenancyConfig{
+		Enabled:               nodeGroupTenancy,
+		TolerationsAnnotation: cfg.TolerationsAnnotation,
+		NodeGroupTaints:       strings.Split(tenancyNodeGroupTaints, ","),
+		ExemptNamespaces:      strings.Split(tenancyExemptNamespaces, ","),
+		ParentLabel:           namespaceParentLabel,
+	}
+	hardeningCfg.Enabled = podHardening
+	hardeningCfg.ExemptNamespaces = strings.Split(hardeningExemptNamespaces, ",")
+	for path, hook := range validatingWebhooks(mgr.GetClient(), mtx, enableClusterResourceQuota, imagePolicyCfg, tenancyCfg, hardeningCfg) {
+		hookServer.Register(path, hook)
 	}
 
 	if ingressSSO {
@@ -161,3 +372,19 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// validatingWebhooks returns the validating webhooks by path. They fail closed, so they are served whether enabled or
+// not, admitting all requests when disabled, as the API server would deny every request on a missing path.
+func validatingWebhooks(c client.Client, mtx *sync.Mutex, enableClusterResourceQuota bool, imagePolicyCfg platformv1.ImagePolicyConfig,
+	tenancyCfg platformv1.TenancyConfig, hardeningCfg platformv1.PodHardeningConfig) map[string]*admission.Webhook {
+	return map[string]*admission.Webhook{
+		"/validate-clusterresourcequota-v1": clusterresourcequota.NewClusterResourceQuotaValidatingWebhook(c, mtx, enableClusterResourceQuota),
+		"/validate-resourcequota-v1":        clusterresourcequota.NewResourceQuotaValidatingWebhook(c, mtx, enableClusterResourceQuota),
+		// the paths of the quota webhooks in the manifests
+		"/validate-clusterresourcequota-platform-flanksource-com-v1": clusterresourcequota.NewClusterResourceQuotaValidatingWebhook(c, mtx, enableClusterResourceQuota),
+		"/validate-resourcequota-platform-flanksource-com-v1":        clusterresourcequota.NewResourceQuotaValidatingWebhook(c, mtx, enableClusterResourceQuota),
+		"/validate-v1-images":        pod.NewValidatingWebhook(c, imagePolicyCfg),
+		"/validate-v1-pod-tenancy":   pod.NewTenancyValidatingWebhook(c, tenancyCfg),
+		"/validate-v1-pod-hardening": pod.NewHardeningValidatingWebhook(c, hardeningCfg),
+	}
+}
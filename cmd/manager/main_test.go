@@ -0,0 +1,106 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// manifestWebhooks returns the failure policies of the webhooks configured in the manifest by path
+func manifestWebhooks(t *testing.T, manifest string) map[string]string {
+	file, err := os.Open(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	webhooks := map[string]string{}
+	decoder := utilyaml.NewYAMLOrJSONDecoder(file, 4096)
+	for {
+		obj := map[string]interface{}{}
+		if err := decoder.Decode(&obj); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("invalid %s: %v", manifest, err)
+		}
+		if kind := obj["kind"]; kind != "ValidatingWebhookConfiguration" && kind != "MutatingWebhookConfiguration" {
+			continue
+		}
+		hooks, _, _ := unstructured.NestedSlice(obj, "webhooks")
+		for _, hook := range hooks {
+			path, _, _ := unstructured.NestedString(hook.(map[string]interface{}), "clientConfig", "service", "path")
+			policy, _, _ := unstructured.NestedString(hook.(map[string]interface{}), "failurePolicy")
+			webhooks[path] = policy
+		}
+	}
+	return webhooks
+}
+
+func TestValidatingWebhooksServeManifests(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+	// the webhooks with the defaults of the flags, which disable the pod validations
+	webhooks := validatingWebhooks(c, &sync.Mutex{}, true, platformv1.ImagePolicyConfig{}, platformv1.TenancyConfig{}, platformv1.PodHardeningConfig{})
+
+	for _, manifest := range []string{"../../config/deploy/manifests.yaml", "../../config/operator/webhook/manifests.yaml"} {
+		webhookPolicies := manifestWebhooks(t, manifest)
+		if len(webhookPolicies) == 0 {
+			t.Fatalf("expected webhooks in %s", manifest)
+		}
+		for path, policy := range webhookPolicies {
+			if _, ok := webhooks[path]; policy == "Fail" && !ok {
+				t.Errorf("expected the fail closed webhook %s of %s to be served by default", path, manifest)
+			}
+		}
+	}
+
+	raw, _ := json.Marshal(&corev1.Pod{Spec: corev1.PodSpec{
+		HostNetwork: true,
+		Containers:  []corev1.Container{{Name: "app", Image: "untrusted.io/app:latest"}},
+	}})
+	request := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Name:      "app",
+		Namespace: "team-a",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+	for _, path := range []string{"/validate-v1-images", "/validate-v1-pod-tenancy", "/validate-v1-pod-hardening"} {
+		hook, ok := webhooks[path]
+		if !ok {
+			t.Errorf("expected %s to be served", path)
+			continue
+		}
+		if response := hook.Handle(context.Background(), request); !response.Allowed {
+			t.Errorf("expected %s to allow pods when disabled, got %v", path, response.Result)
+		}
+	}
+}
@@ -3,10 +3,12 @@ module github.com/flanksource/platform-operator
 go 1.16
 
 require (
+	github.com/docker/distribution v2.7.1+incompatible
 	github.com/flanksource/commons v1.2.0
 	github.com/go-logr/logr v0.3.0
 	github.com/onsi/ginkgo v1.15.0
 	github.com/onsi/gomega v1.10.5
+	github.com/opencontainers/go-digest v1.0.0
 	github.com/pkg/errors v0.9.1
 	github.com/prometheus/client_golang v1.9.0
 	github.com/prometheus/client_model v0.2.0
@@ -18,7 +20,9 @@ require (
 	google.golang.org/grpc v1.35.0
 	google.golang.org/grpc/cmd/protoc-gen-go-grpc v1.1.0
 	google.golang.org/protobuf v1.25.0
+	gopkg.in/inf.v0 v0.9.1
 	k8s.io/api v0.20.4
+	k8s.io/apiextensions-apiserver v0.20.2
 	k8s.io/apimachinery v0.20.4
 	k8s.io/apiserver v0.20.4
 	k8s.io/client-go v11.0.0+incompatible
@@ -36,7 +40,7 @@ replace (
 	k8s.io/apimachinery => k8s.io/apimachinery v0.20.4
 	k8s.io/apiserver => k8s.io/apiserver v0.20.4
 	k8s.io/client-go => k8s.io/client-go v0.20.4
-	sigs.k8s.io/controller-runtime => sigs.k8s.io/controller-runtime v0.8.3
 	launchpad.net/gocheck => github.com/go-check/check v0.0.0-20180628173108-788fd7840127
+	sigs.k8s.io/controller-runtime => sigs.k8s.io/controller-runtime v0.8.3
 	vbom.ml/util => github.com/fvbommel/util v0.0.0-20180919145318-efcd4e0f9787
 )
@@ -0,0 +1,106 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	v2 "github.com/flanksource/platform-operator/pkg/apis/platform/v2"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+var _ conversion.Convertible = &ClusterResourceQuota{}
+
+// ConvertTo converts this ClusterResourceQuota to the hub version (v2)
+func (src *ClusterResourceQuota) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v2.ClusterResourceQuota)
+	dst.ObjectMeta = src.ObjectMeta
+
+	dst.Spec.Selector = v2.NamespaceSelector{
+		MatchLabels:   src.Spec.MatchLabels,
+		AllNamespaces: src.Spec.AllNamespaces,
+	}
+	dst.Spec.Quota = src.Spec.ResourceQuotaSpec
+	dst.Spec.Policy.Reclaim = nil
+	if src.Spec.Reclaim != nil {
+		dst.Spec.Policy.Reclaim = &v2.ReclaimPolicy{
+			ThresholdPercent: src.Spec.Reclaim.ThresholdPercent,
+			Period:           src.Spec.Reclaim.Period,
+			HeadroomPercent:  src.Spec.Reclaim.HeadroomPercent,
+			Resources:        src.Spec.Reclaim.Resources,
+			MinHard:          src.Spec.Reclaim.MinHard,
+		}
+	}
+
+	dst.Status.Total = src.Status.Total
+	dst.Status.Namespaces = nil
+	for _, ns := range src.Status.Namespaces {
+		dst.Status.Namespaces = append(dst.Status.Namespaces, v2.ResourceQuotaStatusByNamespace{
+			Namespace: ns.Namespace,
+			Status:    ns.Status,
+		})
+	}
+	dst.Status.Reclaim = nil
+	if src.Status.Reclaim != nil {
+		dst.Status.Reclaim = &v2.ReclaimStatus{}
+		for _, idle := range src.Status.Reclaim.Idle {
+			dst.Status.Reclaim.Idle = append(dst.Status.Reclaim.Idle, v2.IdleResource(idle))
+		}
+		for _, record := range src.Status.Reclaim.History {
+			dst.Status.Reclaim.History = append(dst.Status.Reclaim.History, v2.ReclaimRecord(record))
+		}
+	}
+	return nil
+}
+
+// ConvertFrom converts from the hub version (v2) to this version
+func (dst *ClusterResourceQuota) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v2.ClusterResourceQuota)
+	dst.ObjectMeta = src.ObjectMeta
+
+	dst.Spec.MatchLabels = src.Spec.Selector.MatchLabels
+	dst.Spec.AllNamespaces = src.Spec.Selector.AllNamespaces
+	dst.Spec.ResourceQuotaSpec = src.Spec.Quota
+	dst.Spec.Reclaim = nil
+	if src.Spec.Policy.Reclaim != nil {
+		dst.Spec.Reclaim = &ReclaimPolicy{
+			ThresholdPercent: src.Spec.Policy.Reclaim.ThresholdPercent,
+			Period:           src.Spec.Policy.Reclaim.Period,
+			HeadroomPercent:  src.Spec.Policy.Reclaim.HeadroomPercent,
+			Resources:        src.Spec.Policy.Reclaim.Resources,
+			MinHard:          src.Spec.Policy.Reclaim.MinHard,
+		}
+	}
+
+	dst.Status.Total = src.Status.Total
+	dst.Status.Namespaces = nil
+	for _, ns := range src.Status.Namespaces {
+		dst.Status.Namespaces = append(dst.Status.Namespaces, ResourceQuotaStatusByNamespace{
+			Namespace: ns.Namespace,
+			Status:    ns.Status,
+		})
+	}
+	dst.Status.Reclaim = nil
+	if src.Status.Reclaim != nil {
+		dst.Status.Reclaim = &ReclaimStatus{}
+		for _, idle := range src.Status.Reclaim.Idle {
+			dst.Status.Reclaim.Idle = append(dst.Status.Reclaim.Idle, IdleResource(idle))
+		}
+		for _, record := range src.Status.Reclaim.History {
+			dst.Status.Reclaim.History = append(dst.Status.Reclaim.History, ReclaimRecord(record))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"reflect"
+	"testing"
+
+	v2 "github.com/flanksource/platform-operator/pkg/apis/platform/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConversionRoundTrip(t *testing.T) {
+	cpu := corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("2")}
+	in := &ClusterResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "team-a", ResourceVersion: "42"},
+		Spec: ClusterResourceQuotaSpec{
+			MatchLabels:       map[string]string{"team": "a"},
+			ResourceQuotaSpec: corev1.ResourceQuotaSpec{Hard: cpu},
+		},
+		Status: ClusterResourceQuotaStatus{
+			Total:      corev1.ResourceQuotaStatus{Hard: cpu, Used: cpu},
+			Namespaces: ResourceQuotasStatusByNamespace{{Namespace: "a", Status: corev1.ResourceQuotaStatus{Used: cpu}}},
+		},
+	}
+
+	hub := &v2.ClusterResourceQuota{}
+	if err := in.ConvertTo(hub); err != nil {
+		t.Fatal(err)
+	}
+	if hub.Spec.Selector.MatchLabels["team"] != "a" || !reflect.DeepEqual(hub.Spec.Quota.Hard, cpu) {
+		t.Errorf("unexpected v2 spec: %+v", hub.Spec)
+	}
+
+	out := &ClusterResourceQuota{}
+	if err := out.ConvertFrom(hub); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip changed the quota:\n%+v\n%+v", in, out)
+	}
+}
@@ -18,6 +18,7 @@ package v1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -25,8 +26,37 @@ import (
 
 // ClusterResourceQuotaSpec defines the desired state of ClusterResourceQuota
 type ClusterResourceQuotaSpec struct {
-	MatchLabels              map[string]string `json:"matchLabels"`
+	// MatchLabels selects the namespaces the quota applies to, it must not be empty unless AllNamespaces is set
+	// +optional
+	MatchLabels map[string]string `json:"matchLabels,omitempty"`
+	// AllNamespaces applies the quota to every namespace in the cluster, it cannot be combined with MatchLabels
+	// +optional
+	AllNamespaces            bool `json:"allNamespaces,omitempty"`
 	corev1.ResourceQuotaSpec `json:",inline"`
+	// Reclaim lowers the hard limits of ResourceQuotas that stay mostly unused, it is disabled when not set
+	// +optional
+	Reclaim *ReclaimPolicy `json:"reclaim,omitempty"`
+}
+
+// ReclaimPolicy defines when the hard limits of idle ResourceQuotas in the selected namespaces are lowered
+type ReclaimPolicy struct {
+	// ThresholdPercent is the percentage of hard below which a resource is considered idle
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	ThresholdPercent int32 `json:"thresholdPercent"`
+	// Period is how long a resource must stay idle before its hard limit is lowered
+	Period metav1.Duration `json:"period"`
+	// HeadroomPercent is the percentage added on top of used when lowering hard
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	HeadroomPercent int32 `json:"headroomPercent,omitempty"`
+	// Resources limits reclamation to these resources, all resources are reclaimed when empty
+	// +optional
+	Resources []corev1.ResourceName `json:"resources,omitempty"`
+	// MinHard is the lowest hard limit of each resource the policy lowers to, resources without a minimum are not
+	// reclaimed while they are not used at all
+	// +optional
+	MinHard corev1.ResourceList `json:"minHard,omitempty"`
 }
 
 // ClusterResourceQuotaStatus defines the observed state of ClusterResourceQuota
@@ -37,10 +67,41 @@ type ClusterResourceQuotaStatus struct {
 
 	// Slices the quota used per namespace
 	Namespaces ResourceQuotasStatusByNamespace `json:"namespaces,omitempty"`
+
+	// Reclaim tracks idle ResourceQuotas and the hard limits lowered by the reclaim policy
+	Reclaim *ReclaimStatus `json:"reclaim,omitempty"`
+}
+
+// ReclaimStatus defines the observed state of the reclaim policy
+type ReclaimStatus struct {
+	// Idle lists the resources currently used below the reclaim threshold
+	Idle []IdleResource `json:"idle,omitempty"`
+	// History lists the most recent hard limits lowered by the reclaim policy
+	History []ReclaimRecord `json:"history,omitempty"`
+}
+
+// IdleResource is a resource of a ResourceQuota used below the reclaim threshold
+type IdleResource struct {
+	Namespace string              `json:"namespace"`
+	Name      string              `json:"name"`
+	Resource  corev1.ResourceName `json:"resource"`
+	// Since is when the resource was first observed below the threshold
+	Since metav1.Time `json:"since"`
+}
+
+// ReclaimRecord is a hard limit of a ResourceQuota lowered by the reclaim policy
+type ReclaimRecord struct {
+	Namespace string              `json:"namespace"`
+	Name      string              `json:"name"`
+	Resource  corev1.ResourceName `json:"resource"`
+	From      resource.Quantity   `json:"from"`
+	To        resource.Quantity   `json:"to"`
+	Time      metav1.Time         `json:"time"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:resource:scope=Cluster,path=clusterresourcequotas
+// +kubebuilder:subresource:status
 
 // ClusterResourceQuota is the Schema for the clusterresourcequotas API
 type ClusterResourceQuota struct {
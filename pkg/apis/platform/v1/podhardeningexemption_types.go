@@ -0,0 +1,79 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PodHardeningExemptionSpec defines the pod hardening rules the selected namespaces and images are exempt from
+type PodHardeningExemptionSpec struct {
+	// NamespaceSelector selects the namespaces the exemption applies to, all namespaces are selected when not set
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Images are the image repositories (e.g. docker.io/cilium or quay.io/prometheus/node-exporter) the exemption
+	// applies to, all images when empty. Pod level rules such as hostNetwork are only exempted when all containers of
+	// the pod are exempted.
+	// +optional
+	Images []string `json:"images,omitempty"`
+	// Rules are the rules exempted, all rules when empty
+	// +optional
+	Rules []PodHardeningRule `json:"rules,omitempty"`
+}
+
+// PodHardeningRule is a pod hardening rule
+// +kubebuilder:validation:Enum=privileged;hostNetwork;hostPID;hostIPC;hostPath;capabilities
+type PodHardeningRule string
+
+const (
+	// PrivilegedRule denies privileged containers
+	PrivilegedRule PodHardeningRule = "privileged"
+	// HostNetworkRule denies pods using the host network
+	HostNetworkRule PodHardeningRule = "hostNetwork"
+	// HostPIDRule denies pods using the host PID namespace
+	HostPIDRule PodHardeningRule = "hostPID"
+	// HostIPCRule denies pods using the host IPC namespace
+	HostIPCRule PodHardeningRule = "hostIPC"
+	// HostPathRule denies hostPath volumes
+	HostPathRule PodHardeningRule = "hostPath"
+	// CapabilitiesRule denies containers adding capabilities
+	CapabilitiesRule PodHardeningRule = "capabilities"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,path=podhardeningexemptions
+
+// PodHardeningExemption is the Schema for the podhardeningexemptions API
+type PodHardeningExemption struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec PodHardeningExemptionSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// PodHardeningExemptionList contains a list of PodHardeningExemption
+type PodHardeningExemptionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PodHardeningExemption `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PodHardeningExemption{}, &PodHardeningExemptionList{})
+}
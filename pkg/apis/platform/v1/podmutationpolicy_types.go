@@ -0,0 +1,90 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PodMutationPolicySpec defines the pod mutations applied to the selected namespaces and pods
+type PodMutationPolicySpec struct {
+	// NamespaceSelector selects the namespaces the policy applies to, all namespaces are selected when not set
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// PodSelector selects the pods the policy applies to, all pods are selected when not set
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// Priority orders the policies applying to the same pod, settings of higher priority policies win
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+
+	// Annotations are the annotation prefixes pods inherit from their namespace
+	// +optional
+	Annotations []string `json:"annotations,omitempty"`
+	// RegistryWhitelist lists image prefixes that are not rewritten to DefaultRegistryPrefix
+	// +optional
+	RegistryWhitelist []string `json:"registryWhitelist,omitempty"`
+	// RegistryMirrors maps registries or repositories (e.g. docker.io, quay.io/org or gcr.io/*) to the mirror their
+	// images are pulled from instead, the most specific match is used
+	// +optional
+	RegistryMirrors map[string]string `json:"registryMirrors,omitempty"`
+	// DefaultRegistryPrefix is the registry prefix applied to images without a mirror
+	// +optional
+	DefaultRegistryPrefix string `json:"defaultRegistryPrefix,omitempty"`
+	// DefaultImagePullSecret is added to pods without an image pull secret
+	// +optional
+	DefaultImagePullSecret string `json:"defaultImagePullSecret,omitempty"`
+	// TolerationsAnnotation is the namespace annotation applied as tolerations on pods
+	// +optional
+	TolerationsAnnotation string `json:"tolerationsAnnotation,omitempty"`
+	// DefaultRequests are the requests of containers not setting a request or limit for a resource
+	// +optional
+	DefaultRequests corev1.ResourceList `json:"defaultRequests,omitempty"`
+	// DefaultLimits are the limits of containers not setting a limit for a resource
+	// +optional
+	DefaultLimits corev1.ResourceList `json:"defaultLimits,omitempty"`
+	// MaxLimitRequestRatio is the maximum ratio of the limit to the request of a resource, defaulted limits are lowered
+	// to it while pods setting both are rejected
+	// +optional
+	MaxLimitRequestRatio corev1.ResourceList `json:"maxLimitRequestRatio,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,path=podmutationpolicies
+// +kubebuilder:printcolumn:name="Priority",type=integer,JSONPath=`.spec.priority`
+
+// PodMutationPolicy is the Schema for the podmutationpolicies API
+type PodMutationPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec PodMutationPolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// PodMutationPolicyList contains a list of PodMutationPolicy
+type PodMutationPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PodMutationPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PodMutationPolicy{}, &PodMutationPolicyList{})
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SidecarTemplateSpec defines the containers and volumes injected into pods opting in to the template. String fields
+// are Go templates rendered with the .Pod and .Namespace being mutated, e.g. {{ .Namespace.Name }}.
+type SidecarTemplateSpec struct {
+	// NativeSidecars are injected as init containers with restartPolicy: Always (Kubernetes 1.28+), ahead of the
+	// init containers of the pod so that they are running before those start
+	// +optional
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=array
+	// +kubebuilder:pruning:PreserveUnknownFields
+	NativeSidecars []corev1.Container `json:"nativeSidecars,omitempty"`
+	// InitContainers are injected after the native sidecars and ahead of the init containers of the pod
+	// +optional
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=array
+	// +kubebuilder:pruning:PreserveUnknownFields
+	InitContainers []corev1.Container `json:"initContainers,omitempty"`
+	// Containers are appended to the containers of the pod
+	// +optional
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=array
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Containers []corev1.Container `json:"containers,omitempty"`
+	// Volumes are added to the pod unless it already has a volume with the same name
+	// +optional
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=array
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Volumes []corev1.Volume `json:"volumes,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,path=sidecartemplates
+
+// SidecarTemplate is the Schema for the sidecartemplates API
+type SidecarTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec SidecarTemplateSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// SidecarTemplateList contains a list of SidecarTemplate
+type SidecarTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SidecarTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SidecarTemplate{}, &SidecarTemplateList{})
+}
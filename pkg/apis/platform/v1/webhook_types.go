@@ -1,10 +1,70 @@
 package v1
 
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
 type PodMutaterConfig struct {
-	AnnotationsMap         map[string]bool
-	Annotations            []string
-	RegistryWhitelist      []string
-	DefaultRegistryPrefix  string
-	DefaultImagePullSecret string
-	TolerationsAnnotation  string
+	AnnotationsMap            map[string]bool
+	Annotations               []string
+	Labels                    []string
+	RegistryWhitelist         []string
+	RegistryMirrors           map[string]string
+	DefaultRegistryPrefix     string
+	DefaultImagePullSecret    string
+	ImagePullSecrets          []string
+	RegistryPullSecrets       map[string]string
+	VerifyImagePullSecrets    bool
+	TolerationsAnnotation     string
+	NodeSelectorAnnotation    string
+	NodeSelectorWhitelist     map[string]map[string]string
+	DefaultRequests           corev1.ResourceList
+	DefaultLimits             corev1.ResourceList
+	MaxLimitRequestRatio      corev1.ResourceList
+	SidecarTemplatesNamespace string
+	Env                       map[string]string
+	CABundleConfigMap         string
+	CABundleKey               string
+	PinImageDigests           bool
+	ImageDigestCacheTTL       time.Duration
+	ImageDigestFailClosed     bool
+}
+
+type ImagePolicyConfig struct {
+	// Enabled is false when the webhook is registered only so that its fail closed configuration admits all requests
+	Enabled           bool
+	AllowedRegistries []string
+	AuditOnly         bool
+}
+
+// ReplicationConfig is the source object copied into the namespaces matching the selector
+type ReplicationConfig struct {
+	SourceNamespace   string
+	SourceName        string
+	NamespaceSelector string
+}
+
+type TenancyConfig struct {
+	// Enabled is false when the webhook is registered only so that its fail closed configuration admits all pods
+	Enabled               bool
+	TolerationsAnnotation string
+	// NodeGroupTaints are the taint keys, or key prefixes ending with /, of tolerations dedicating node groups
+	NodeGroupTaints  []string
+	ExemptNamespaces []string
+	ParentLabel      string
+}
+
+// InheritanceConfig is the label prefixes resources of the kinds inherit from their namespace
+type InheritanceConfig struct {
+	Labels []string
+	Kinds  []string
+}
+
+type PodHardeningConfig struct {
+	// Enabled is false when the webhook is registered only so that its fail closed configuration admits all pods
+	Enabled          bool
+	ExemptNamespaces []string
+	WarnOnly         bool
 }
@@ -21,6 +21,8 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -94,6 +96,11 @@ func (in *ClusterResourceQuotaSpec) DeepCopyInto(out *ClusterResourceQuotaSpec)
 		}
 	}
 	in.ResourceQuotaSpec.DeepCopyInto(&out.ResourceQuotaSpec)
+	if in.Reclaim != nil {
+		in, out := &in.Reclaim, &out.Reclaim
+		*out = new(ReclaimPolicy)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterResourceQuotaSpec.
@@ -117,6 +124,11 @@ func (in *ClusterResourceQuotaStatus) DeepCopyInto(out *ClusterResourceQuotaStat
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Reclaim != nil {
+		in, out := &in.Reclaim, &out.Reclaim
+		*out = new(ReclaimStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterResourceQuotaStatus.
@@ -129,6 +141,175 @@ func (in *ClusterResourceQuotaStatus) DeepCopy() *ClusterResourceQuotaStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IdleResource) DeepCopyInto(out *IdleResource) {
+	*out = *in
+	in.Since.DeepCopyInto(&out.Since)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IdleResource.
+func (in *IdleResource) DeepCopy() *IdleResource {
+	if in == nil {
+		return nil
+	}
+	out := new(IdleResource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImagePolicyConfig) DeepCopyInto(out *ImagePolicyConfig) {
+	*out = *in
+	if in.AllowedRegistries != nil {
+		in, out := &in.AllowedRegistries, &out.AllowedRegistries
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImagePolicyConfig.
+func (in *ImagePolicyConfig) DeepCopy() *ImagePolicyConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(ImagePolicyConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InheritanceConfig) DeepCopyInto(out *InheritanceConfig) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Kinds != nil {
+		in, out := &in.Kinds, &out.Kinds
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InheritanceConfig.
+func (in *InheritanceConfig) DeepCopy() *InheritanceConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(InheritanceConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodHardeningConfig) DeepCopyInto(out *PodHardeningConfig) {
+	*out = *in
+	if in.ExemptNamespaces != nil {
+		in, out := &in.ExemptNamespaces, &out.ExemptNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodHardeningConfig.
+func (in *PodHardeningConfig) DeepCopy() *PodHardeningConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(PodHardeningConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodHardeningExemption) DeepCopyInto(out *PodHardeningExemption) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodHardeningExemption.
+func (in *PodHardeningExemption) DeepCopy() *PodHardeningExemption {
+	if in == nil {
+		return nil
+	}
+	out := new(PodHardeningExemption)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PodHardeningExemption) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodHardeningExemptionList) DeepCopyInto(out *PodHardeningExemptionList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]PodHardeningExemption, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodHardeningExemptionList.
+func (in *PodHardeningExemptionList) DeepCopy() *PodHardeningExemptionList {
+	if in == nil {
+		return nil
+	}
+	out := new(PodHardeningExemptionList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PodHardeningExemptionList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodHardeningExemptionSpec) DeepCopyInto(out *PodHardeningExemptionSpec) {
+	*out = *in
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Images != nil {
+		in, out := &in.Images, &out.Images
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]PodHardeningRule, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodHardeningExemptionSpec.
+func (in *PodHardeningExemptionSpec) DeepCopy() *PodHardeningExemptionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PodHardeningExemptionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PodMutaterConfig) DeepCopyInto(out *PodMutaterConfig) {
 	*out = *in
@@ -144,11 +325,80 @@ func (in *PodMutaterConfig) DeepCopyInto(out *PodMutaterConfig) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.RegistryWhitelist != nil {
 		in, out := &in.RegistryWhitelist, &out.RegistryWhitelist
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.RegistryMirrors != nil {
+		in, out := &in.RegistryMirrors, &out.RegistryMirrors
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RegistryPullSecrets != nil {
+		in, out := &in.RegistryPullSecrets, &out.RegistryPullSecrets
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.NodeSelectorWhitelist != nil {
+		in, out := &in.NodeSelectorWhitelist, &out.NodeSelectorWhitelist
+		*out = make(map[string]map[string]string, len(*in))
+		for key, val := range *in {
+			var outVal map[string]string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = make(map[string]string, len(*in))
+				for key, val := range *in {
+					(*out)[key] = val
+				}
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.DefaultRequests != nil {
+		in, out := &in.DefaultRequests, &out.DefaultRequests
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.DefaultLimits != nil {
+		in, out := &in.DefaultLimits, &out.DefaultLimits
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.MaxLimitRequestRatio != nil {
+		in, out := &in.MaxLimitRequestRatio, &out.MaxLimitRequestRatio
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.Env != nil {
+		in, out := &in.Env, &out.Env
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodMutaterConfig.
@@ -161,6 +411,217 @@ func (in *PodMutaterConfig) DeepCopy() *PodMutaterConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodMutationPolicy) DeepCopyInto(out *PodMutationPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodMutationPolicy.
+func (in *PodMutationPolicy) DeepCopy() *PodMutationPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(PodMutationPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PodMutationPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodMutationPolicyList) DeepCopyInto(out *PodMutationPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]PodMutationPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodMutationPolicyList.
+func (in *PodMutationPolicyList) DeepCopy() *PodMutationPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(PodMutationPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PodMutationPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodMutationPolicySpec) DeepCopyInto(out *PodMutationPolicySpec) {
+	*out = *in
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PodSelector != nil {
+		in, out := &in.PodSelector, &out.PodSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RegistryWhitelist != nil {
+		in, out := &in.RegistryWhitelist, &out.RegistryWhitelist
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RegistryMirrors != nil {
+		in, out := &in.RegistryMirrors, &out.RegistryMirrors
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.DefaultRequests != nil {
+		in, out := &in.DefaultRequests, &out.DefaultRequests
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.DefaultLimits != nil {
+		in, out := &in.DefaultLimits, &out.DefaultLimits
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.MaxLimitRequestRatio != nil {
+		in, out := &in.MaxLimitRequestRatio, &out.MaxLimitRequestRatio
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodMutationPolicySpec.
+func (in *PodMutationPolicySpec) DeepCopy() *PodMutationPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PodMutationPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReclaimPolicy) DeepCopyInto(out *ReclaimPolicy) {
+	*out = *in
+	out.Period = in.Period
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = make([]corev1.ResourceName, len(*in))
+		copy(*out, *in)
+	}
+	if in.MinHard != nil {
+		in, out := &in.MinHard, &out.MinHard
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReclaimPolicy.
+func (in *ReclaimPolicy) DeepCopy() *ReclaimPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(ReclaimPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReclaimRecord) DeepCopyInto(out *ReclaimRecord) {
+	*out = *in
+	out.From = in.From.DeepCopy()
+	out.To = in.To.DeepCopy()
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReclaimRecord.
+func (in *ReclaimRecord) DeepCopy() *ReclaimRecord {
+	if in == nil {
+		return nil
+	}
+	out := new(ReclaimRecord)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReclaimStatus) DeepCopyInto(out *ReclaimStatus) {
+	*out = *in
+	if in.Idle != nil {
+		in, out := &in.Idle, &out.Idle
+		*out = make([]IdleResource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]ReclaimRecord, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReclaimStatus.
+func (in *ReclaimStatus) DeepCopy() *ReclaimStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ReclaimStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReplicationConfig) DeepCopyInto(out *ReplicationConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReplicationConfig.
+func (in *ReplicationConfig) DeepCopy() *ReplicationConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(ReplicationConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ResourceQuotaStatusByNamespace) DeepCopyInto(out *ResourceQuotaStatusByNamespace) {
 	*out = *in
@@ -197,3 +658,129 @@ func (in ResourceQuotasStatusByNamespace) DeepCopy() ResourceQuotasStatusByNames
 	in.DeepCopyInto(out)
 	return *out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SidecarTemplate) DeepCopyInto(out *SidecarTemplate) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SidecarTemplate.
+func (in *SidecarTemplate) DeepCopy() *SidecarTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(SidecarTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *SidecarTemplate) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SidecarTemplateList) DeepCopyInto(out *SidecarTemplateList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]SidecarTemplate, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SidecarTemplateList.
+func (in *SidecarTemplateList) DeepCopy() *SidecarTemplateList {
+	if in == nil {
+		return nil
+	}
+	out := new(SidecarTemplateList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *SidecarTemplateList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SidecarTemplateSpec) DeepCopyInto(out *SidecarTemplateSpec) {
+	*out = *in
+	if in.NativeSidecars != nil {
+		in, out := &in.NativeSidecars, &out.NativeSidecars
+		*out = make([]corev1.Container, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.InitContainers != nil {
+		in, out := &in.InitContainers, &out.InitContainers
+		*out = make([]corev1.Container, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Containers != nil {
+		in, out := &in.Containers, &out.Containers
+		*out = make([]corev1.Container, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Volumes != nil {
+		in, out := &in.Volumes, &out.Volumes
+		*out = make([]corev1.Volume, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SidecarTemplateSpec.
+func (in *SidecarTemplateSpec) DeepCopy() *SidecarTemplateSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SidecarTemplateSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TenancyConfig) DeepCopyInto(out *TenancyConfig) {
+	*out = *in
+	if in.NodeGroupTaints != nil {
+		in, out := &in.NodeGroupTaints, &out.NodeGroupTaints
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExemptNamespaces != nil {
+		in, out := &in.ExemptNamespaces, &out.ExemptNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TenancyConfig.
+func (in *TenancyConfig) DeepCopy() *TenancyConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(TenancyConfig)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+
+// ClusterResourceQuotaSpec defines the desired state of ClusterResourceQuota
+type ClusterResourceQuotaSpec struct {
+	// Selector defines the namespaces the quota applies to
+	Selector NamespaceSelector `json:"selector"`
+	// Quota defines the hard limits enforced across all selected namespaces
+	Quota corev1.ResourceQuotaSpec `json:"quota"`
+	// Policy defines how the quota is distributed and enforced
+	// +optional
+	Policy ClusterResourceQuotaPolicy `json:"policy,omitempty"`
+}
+
+// NamespaceSelector selects namespaces, MatchLabels must not be empty unless AllNamespaces is set
+type NamespaceSelector struct {
+	// MatchLabels selects namespaces that have all of these labels
+	// +optional
+	MatchLabels map[string]string `json:"matchLabels,omitempty"`
+	// AllNamespaces selects every namespace in the cluster, it cannot be combined with MatchLabels
+	// +optional
+	AllNamespaces bool `json:"allNamespaces,omitempty"`
+}
+
+// ClusterResourceQuotaPolicy defines how the quota is distributed and enforced
+type ClusterResourceQuotaPolicy struct {
+	// Reclaim lowers the hard limits of ResourceQuotas that stay mostly unused, it is disabled when not set
+	// +optional
+	Reclaim *ReclaimPolicy `json:"reclaim,omitempty"`
+}
+
+// ReclaimPolicy defines when the hard limits of idle ResourceQuotas in the selected namespaces are lowered
+type ReclaimPolicy struct {
+	// ThresholdPercent is the percentage of hard below which a resource is considered idle
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	ThresholdPercent int32 `json:"thresholdPercent"`
+	// Period is how long a resource must stay idle before its hard limit is lowered
+	Period metav1.Duration `json:"period"`
+	// HeadroomPercent is the percentage added on top of used when lowering hard
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	HeadroomPercent int32 `json:"headroomPercent,omitempty"`
+	// Resources limits reclamation to these resources, all resources are reclaimed when empty
+	// +optional
+	Resources []corev1.ResourceName `json:"resources,omitempty"`
+	// MinHard is the lowest hard limit of each resource the policy lowers to, resources without a minimum are not
+	// reclaimed while they are not used at all
+	// +optional
+	MinHard corev1.ResourceList `json:"minHard,omitempty"`
+}
+
+// ClusterResourceQuotaStatus defines the observed state of ClusterResourceQuota
+type ClusterResourceQuotaStatus struct {
+	// Total defines the actual enforced quota and its current usage across all namespaces
+	Total corev1.ResourceQuotaStatus `json:"total,omitempty"`
+
+	// Slices the quota used per namespace
+	Namespaces ResourceQuotasStatusByNamespace `json:"namespaces,omitempty"`
+
+	// Reclaim tracks idle ResourceQuotas and the hard limits lowered by the reclaim policy
+	Reclaim *ReclaimStatus `json:"reclaim,omitempty"`
+}
+
+// ReclaimStatus defines the observed state of the reclaim policy
+type ReclaimStatus struct {
+	// Idle lists the resources currently used below the reclaim threshold
+	Idle []IdleResource `json:"idle,omitempty"`
+	// History lists the most recent hard limits lowered by the reclaim policy
+	History []ReclaimRecord `json:"history,omitempty"`
+}
+
+// IdleResource is a resource of a ResourceQuota used below the reclaim threshold
+type IdleResource struct {
+	Namespace string              `json:"namespace"`
+	Name      string              `json:"name"`
+	Resource  corev1.ResourceName `json:"resource"`
+	// Since is when the resource was first observed below the threshold
+	Since metav1.Time `json:"since"`
+}
+
+// ReclaimRecord is a hard limit of a ResourceQuota lowered by the reclaim policy
+type ReclaimRecord struct {
+	Namespace string              `json:"namespace"`
+	Name      string              `json:"name"`
+	Resource  corev1.ResourceName `json:"resource"`
+	From      resource.Quantity   `json:"from"`
+	To        resource.Quantity   `json:"to"`
+	Time      metav1.Time         `json:"time"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,path=clusterresourcequotas
+// +kubebuilder:subresource:status
+// +kubebuilder:storageversion
+
+// ClusterResourceQuota is the Schema for the clusterresourcequotas API
+type ClusterResourceQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec defines the desired quota
+	Spec ClusterResourceQuotaSpec `json:"spec,omitempty"`
+
+	// Status defines the actual enforced quota and its current usage
+	Status ClusterResourceQuotaStatus `json:"status,omitempty"`
+}
+
+// ResourceQuotasStatusByNamespace bundles multiple ResourceQuotaStatusByNamespace
+type ResourceQuotasStatusByNamespace []ResourceQuotaStatusByNamespace
+
+// ResourceQuotaStatusByNamespace gives status for a particular name
+type ResourceQuotaStatusByNamespace struct {
+	// Namespace the project this status applies to
+	Namespace string `json:"namespace"`
+
+	// Status indicates how many resources have been consumed by this project
+	Status corev1.ResourceQuotaStatus `json:"status"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterResourceQuotaList contains a list of ClusterResourceQuota
+type ClusterResourceQuotaList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterResourceQuota `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterResourceQuota{}, &ClusterResourceQuotaList{})
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cabundle
+
+import (
+	"reflect"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	"github.com/flanksource/platform-operator/pkg/controllers/replication"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	name = "cabundle-controller"
+
+	// ReplicatedFromAnnotation is set on copies of the source configmap to <namespace>/<name> of the source
+	ReplicatedFromAnnotation = replication.ReplicatedFromAnnotation
+)
+
+// configMaps are replicated with their data and binary data
+var configMaps = replication.Kind{
+	Name:     "configmap",
+	Resource: "configmaps",
+	New:      func() client.Object { return &corev1.ConfigMap{} },
+	Sync: func(src, dst client.Object) (bool, bool) {
+		from, to := src.(*corev1.ConfigMap), dst.(*corev1.ConfigMap)
+		if reflect.DeepEqual(to.Data, from.Data) && reflect.DeepEqual(to.BinaryData, from.BinaryData) {
+			return false, false
+		}
+		to.Data, to.BinaryData = from.Data, from.BinaryData
+		return true, false
+	},
+}
+
+// Add replicates the source CA bundle configmap into every namespace matching the selector
+func Add(mgr manager.Manager, cfg platformv1.ReplicationConfig) error {
+	r, err := newReconciler(mgr.GetClient(), mgr.GetScheme(), cfg)
+	if err != nil {
+		return err
+	}
+	return r.Add(mgr)
+}
+
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;delete
+
+func newReconciler(c client.Client, scheme *runtime.Scheme, cfg platformv1.ReplicationConfig) (*replication.Reconciler, error) {
+	return replication.NewReconciler(c, scheme, name, configMaps, cfg)
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cabundle
+
+import (
+	"context"
+	"testing"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcile(t *testing.T) {
+	src := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "ca-bundle", Namespace: "platform-system"},
+		Data:       map[string]string{"ca.crt": "corp"},
+	}
+	tenant := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}}
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(src, tenant).Build()
+	r, err := newReconciler(c, scheme.Scheme, platformv1.ReplicationConfig{SourceNamespace: "platform-system", SourceName: "ca-bundle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	reconcileTenant := func() {
+		if _, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Name: "tenant"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	getCopy := func() (*corev1.ConfigMap, error) {
+		cm := &corev1.ConfigMap{}
+		return cm, c.Get(ctx, types.NamespacedName{Namespace: "tenant", Name: "ca-bundle"}, cm)
+	}
+
+	reconcileTenant()
+	replica, err := getCopy()
+	if err != nil || replica.Data["ca.crt"] != "corp" {
+		t.Fatalf("expected the configmap to be replicated, got %v %v", replica, err)
+	}
+
+	src.Data["ca.crt"] = "rotated"
+	if err := c.Update(ctx, src); err != nil {
+		t.Fatal(err)
+	}
+	reconcileTenant()
+	if replica, _ := getCopy(); replica.Data["ca.crt"] != "rotated" {
+		t.Errorf("expected the copy to be updated, got %v", replica.Data)
+	}
+
+	if err := c.Delete(ctx, src); err != nil {
+		t.Fatal(err)
+	}
+	reconcileTenant()
+	if _, err := getCopy(); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the copy to be removed with the source, got %v", err)
+	}
+}
@@ -18,6 +18,7 @@ package clusterresourcequota
 
 import (
 	"context"
+	"fmt"
 	"math/rand"
 	"strings"
 	"sync"
@@ -29,6 +30,7 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -50,9 +52,10 @@ func Add(mgr manager.Manager) error {
 
 func newReconciler(mgr manager.Manager) reconcile.Reconciler {
 	return &ReconcileClusterResourceQuota{
-		mtx:    &sync.Mutex{},
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		mtx:      &sync.Mutex{},
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		recorder: mgr.GetEventRecorderFor(name),
 	}
 }
 
@@ -101,12 +104,14 @@ var _ reconcile.Reconciler = &ReconcileClusterResourceQuota{}
 type ReconcileClusterResourceQuota struct {
 	mtx *sync.Mutex
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	recorder record.EventRecorder
 }
 
 // +kubebuilder:rbac:groups=platform.flanksource.com,resources=clusterresourcequotas,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=platform.flanksource.com,resources=clusterresourcequotas/status,verbs=get;update;patch
-// +kubebuilder:rbac:groups="",resources=resourcequotas,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=resourcequotas,verbs=get;list;watch;update
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *ReconcileClusterResourceQuota) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
 	r.mtx.Lock()
@@ -125,6 +130,16 @@ func (r *ReconcileClusterResourceQuota) Reconcile(ctx context.Context, request r
 		return reconcile.Result{}, err
 	}
 
+	result := reconcile.Result{}
+	if quota.Spec.Reclaim != nil {
+		if err := r.reclaim(ctx, quota, existing); err != nil {
+			return reconcile.Result{}, err
+		}
+		result.RequeueAfter = reclaimInterval
+	} else {
+		quota.Status.Reclaim = nil
+	}
+
 	quota.Status.Total.Hard = sumOfHard(existing)
 	quota.Status.Total.Used = sumOfUsed(existing)
 	quota.Status.Namespaces = platformv1.ResourceQuotasStatusByNamespace{}
@@ -148,5 +163,38 @@ func (r *ReconcileClusterResourceQuota) Reconcile(ctx context.Context, request r
 		return reconcile.Result{}, err
 	}
 
-	return reconcile.Result{}, nil
+	return result, nil
+}
+
+func (r *ReconcileClusterResourceQuota) reclaim(ctx context.Context, quota *platformv1.ClusterResourceQuota, existing []corev1.ResourceQuota) error {
+	exempt := map[string]bool{}
+	for _, rq := range existing {
+		if _, ok := exempt[rq.Namespace]; ok {
+			continue
+		}
+		namespace := corev1.Namespace{}
+		if err := r.Get(ctx, namespaceKey(&rq), &namespace); err != nil {
+			return err
+		}
+		exempt[rq.Namespace] = namespace.Annotations[reclaimExemptAnnotation] == "true"
+	}
+
+	status, changed, records := reclaim(quota.Spec.Reclaim, quota.Status.Reclaim, existing, exempt, time.Now())
+	for i := range changed {
+		if err := r.Update(ctx, &changed[i]); err != nil {
+			return err
+		}
+	}
+	for _, record := range records {
+		log.Info("Reclaimed idle quota", "quota", quota.Name, "namespace", record.Namespace, "resourcequota", record.Name, "resource", record.Resource, "from", qtyString(record.From), "to", qtyString(record.To))
+		msg := fmt.Sprintf("Lowered %s of ResourceQuota %s/%s from %s to %s after being idle for %s", record.Resource, record.Namespace, record.Name, qtyString(record.From), qtyString(record.To), quota.Spec.Reclaim.Period.Duration)
+		r.recorder.Event(quota, corev1.EventTypeNormal, "Reclaimed", msg)
+		for i := range changed {
+			if changed[i].Namespace == record.Namespace && changed[i].Name == record.Name {
+				r.recorder.Event(&changed[i], corev1.EventTypeNormal, "Reclaimed", msg)
+			}
+		}
+	}
+	quota.Status.Reclaim = status
+	return nil
 }
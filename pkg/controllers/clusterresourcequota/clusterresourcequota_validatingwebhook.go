@@ -24,6 +24,8 @@ import (
 	"sync"
 
 	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
@@ -59,6 +61,22 @@ func (v *validatingClusterResourceQuotaHandler) Handle(ctx context.Context, req
 		return admission.Errored(http.StatusBadRequest, err)
 	}
 
+	if req.Operation == admissionv1.Update {
+		old := &platformv1.ClusterResourceQuota{}
+		if err := v.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		// quotas created before their specs were validated can still be relabelled or rewritten by the storage
+		// version migration as long as their spec is unchanged
+		if equality.Semantic.DeepEqual(old.Spec, crq.Spec) {
+			return admission.Allowed("")
+		}
+	}
+
+	if errs := validateSpec(crq); len(errs) > 0 {
+		return admission.Denied(fmt.Sprintf("invalid ClusterResourceQuota/%s: %s", crq.Name, errs.ToAggregate().Error()))
+	}
+
 	if !v.validationEnabled {
 		log.Info("validate resource quota flag is not enabled. All requests will be declared valid")
 		return admission.Allowed("")
@@ -0,0 +1,154 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterresourcequota
+
+import (
+	"time"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+
+	"gopkg.in/inf.v0"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// reclaimExemptAnnotation on a namespace excludes its ResourceQuotas from the reclaim policy
+	reclaimExemptAnnotation = "platform.flanksource.com/reclaim-exempt"
+
+	// reclaimHistoryLimit is the number of reclaim records kept in the status
+	reclaimHistoryLimit = 20
+
+	// reclaimInterval is how often quotas with a reclaim policy are re-evaluated
+	reclaimInterval = 10 * time.Minute
+)
+
+type idleKey struct {
+	namespace, name string
+	resource        corev1.ResourceName
+}
+
+// reclaim tracks which resources of the quotas are idle and lowers the hard limit of those that have been idle for
+// longer than the policy period, never below the MinHard of the policy. It returns the new status, the ResourceQuotas that were changed and what was lowered.
+func reclaim(policy *platformv1.ReclaimPolicy, previous *platformv1.ReclaimStatus, quotas []corev1.ResourceQuota, exempt map[string]bool, now time.Time) (*platformv1.ReclaimStatus, []corev1.ResourceQuota, []platformv1.ReclaimRecord) {
+	idleSince := map[idleKey]metav1.Time{}
+	status := &platformv1.ReclaimStatus{}
+	if previous != nil {
+		for _, idle := range previous.Idle {
+			idleSince[idleKey{idle.Namespace, idle.Name, idle.Resource}] = idle.Since
+		}
+		status.History = previous.History
+	}
+
+	changed := []corev1.ResourceQuota{}
+	records := []platformv1.ReclaimRecord{}
+	for i := range quotas {
+		rq := &quotas[i]
+		if exempt[rq.Namespace] {
+			continue
+		}
+		lowered := false
+		for _, name := range sortedResourceNames(rq.Spec.Hard) {
+			if !reclaimable(policy, name) {
+				continue
+			}
+			hard := rq.Spec.Hard[name]
+			used, ok := rq.Status.Used[name]
+			if !ok || !isIdle(hard, used, policy.ThresholdPercent) {
+				continue
+			}
+			floor, hasFloor := policy.MinHard[name]
+			if used.IsZero() && !hasFloor {
+				// lowering hard to the headroom of nothing would lock the namespace out of the resource
+				continue
+			}
+
+			key := idleKey{rq.Namespace, rq.Name, name}
+			since, ok := idleSince[key]
+			if !ok {
+				since = metav1.NewTime(now)
+			}
+
+			target := withHeadroom(used, policy.HeadroomPercent)
+			if hasFloor && target.Cmp(floor) < 0 {
+				target = floor.DeepCopy()
+			}
+			if now.Sub(since.Time) >= policy.Period.Duration && target.Cmp(hard) < 0 {
+				record := platformv1.ReclaimRecord{
+					Namespace: rq.Namespace,
+					Name:      rq.Name,
+					Resource:  name,
+					From:      hard.DeepCopy(),
+					To:        target,
+					Time:      metav1.NewTime(now),
+				}
+				rq.Spec.Hard[name] = target
+				records = append(records, record)
+				lowered = true
+				// restart tracking against the new hard limit
+				since = metav1.NewTime(now)
+			}
+			status.Idle = append(status.Idle, platformv1.IdleResource{
+				Namespace: rq.Namespace,
+				Name:      rq.Name,
+				Resource:  name,
+				Since:     since,
+			})
+		}
+		if lowered {
+			changed = append(changed, *rq)
+		}
+	}
+
+	status.History = append(status.History, records...)
+	if len(status.History) > reclaimHistoryLimit {
+		status.History = status.History[len(status.History)-reclaimHistoryLimit:]
+	}
+	return status, changed, records
+}
+
+func reclaimable(policy *platformv1.ReclaimPolicy, name corev1.ResourceName) bool {
+	if len(policy.Resources) == 0 {
+		return true
+	}
+	for _, resource := range policy.Resources {
+		if resource == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isIdle returns true if used is below thresholdPercent of hard
+func isIdle(hard, used resource.Quantity, thresholdPercent int32) bool {
+	threshold := new(inf.Dec).Mul(hard.AsDec(), inf.NewDec(int64(thresholdPercent), 2))
+	return used.AsDec().Cmp(threshold) < 0
+}
+
+// withHeadroom returns used plus headroomPercent, rounded up to a whole unit unless used is fractional (e.g. cpu)
+func withHeadroom(used resource.Quantity, headroomPercent int32) resource.Quantity {
+	value := used.AsDec()
+	target := new(inf.Dec).Mul(value, inf.NewDec(int64(100+headroomPercent), 2))
+	scale := inf.Scale(3)
+	if new(inf.Dec).Round(value, 0, inf.RoundDown).Cmp(value) == 0 {
+		scale = 0
+	}
+	result := resource.Quantity{Format: used.Format}
+	result.AsDec().Round(target, scale, inf.RoundCeil)
+	return result
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterresourcequota
+
+import (
+	"testing"
+	"time"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWithHeadroom(t *testing.T) {
+	fixtures := map[string]string{
+		"500m":  "600m",
+		"3":     "4",
+		"10":    "12",
+		"1Gi":   "1288490189",
+		"0":     "0",
+		"100Pi": "120Pi",
+	}
+
+	for used, expected := range fixtures {
+		t.Run(used, func(t *testing.T) {
+			result := withHeadroom(resource.MustParse(used), 20)
+			if result.Cmp(resource.MustParse(expected)) != 0 {
+				t.Errorf("expected %s, got %s", expected, result.String())
+			}
+		})
+	}
+}
+
+func TestIsIdle(t *testing.T) {
+	fixtures := []struct {
+		hard, used string
+		idle       bool
+	}{
+		{"4", "1", true},
+		{"4", "2", false},
+		{"1", "499m", true},
+		// large quantities overflow int64 as milli values
+		{"100Pi", "10Pi", true},
+		{"100Pi", "60Pi", false},
+		{"10Pi", "6Pi", false},
+	}
+	for _, fixture := range fixtures {
+		if idle := isIdle(resource.MustParse(fixture.hard), resource.MustParse(fixture.used), 50); idle != fixture.idle {
+			t.Errorf("expected %s of %s idle=%v, got %v", fixture.used, fixture.hard, fixture.idle, idle)
+		}
+	}
+}
+
+func TestReclaim(t *testing.T) {
+	policy := &platformv1.ReclaimPolicy{
+		ThresholdPercent: 50,
+		HeadroomPercent:  20,
+		Period:           metav1.Duration{Duration: time.Hour},
+	}
+	start := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	quotas := func() []corev1.ResourceQuota {
+		return []corev1.ResourceQuota{
+			newResourceQuota("idle", "4", "1"),
+			newResourceQuota("busy", "4", "3"),
+			newResourceQuota("exempt", "4", "0"),
+			newResourceQuota("unused", "4", "0"),
+		}
+	}
+	exempt := map[string]bool{"exempt": true}
+
+	status, changed, _ := reclaim(policy, nil, quotas(), exempt, start)
+	if len(changed) != 0 || len(status.Idle) != 1 || status.Idle[0].Namespace != "idle" {
+		t.Fatalf("expected only the idle namespace to be tracked: %+v", status)
+	}
+
+	status, changed, _ = reclaim(policy, status, quotas(), exempt, start.Add(30*time.Minute))
+	if len(changed) != 0 || !status.Idle[0].Since.Time.Equal(start) {
+		t.Fatalf("expected no change before the period has passed: %+v", status)
+	}
+
+	status, changed, records := reclaim(policy, status, quotas(), exempt, start.Add(time.Hour))
+	if len(changed) != 1 || len(records) != 1 || len(status.History) != 1 {
+		t.Fatalf("expected the idle quota to be reclaimed: %+v", status)
+	}
+	hard := changed[0].Spec.Hard[corev1.ResourceCPU]
+	if hard.String() != "2" {
+		t.Errorf("expected hard to be lowered to used plus headroom, got %s", hard.String())
+	}
+	for _, rq := range changed {
+		if rq.Namespace == "unused" {
+			t.Errorf("expected quotas without usage not to be lowered to zero: %+v", rq.Spec.Hard)
+		}
+	}
+}
+
+func TestReclaimMinHard(t *testing.T) {
+	policy := &platformv1.ReclaimPolicy{
+		ThresholdPercent: 50,
+		HeadroomPercent:  20,
+		Period:           metav1.Duration{Duration: time.Hour},
+		MinHard:          corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1500m")},
+	}
+	start := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	quotas := func() []corev1.ResourceQuota {
+		return []corev1.ResourceQuota{
+			newResourceQuota("unused", "4", "0"),
+			newResourceQuota("idle", "4", "1"),
+			newResourceQuota("small", "1", "0"),
+		}
+	}
+
+	status, _, _ := reclaim(policy, nil, quotas(), nil, start)
+	if len(status.Idle) != 3 {
+		t.Fatalf("expected quotas without usage to be tracked when a minimum is set: %+v", status)
+	}
+
+	_, changed, _ := reclaim(policy, status, quotas(), nil, start.Add(time.Hour))
+	hard := map[string]string{}
+	for _, rq := range changed {
+		cpu := rq.Spec.Hard[corev1.ResourceCPU]
+		hard[rq.Namespace] = cpu.String()
+	}
+	expected := map[string]string{
+		// lowered to the minimum rather than the headroom of nothing
+		"unused": "1500m",
+		// used plus headroom is above the minimum
+		"idle": "2",
+	}
+	if len(hard) != len(expected) {
+		t.Fatalf("expected %v to be lowered, got %v", expected, hard)
+	}
+	for namespace, value := range expected {
+		if hard[namespace] != value {
+			t.Errorf("expected hard of %s to be lowered to %s, got %s", namespace, value, hard[namespace])
+		}
+	}
+}
@@ -49,13 +49,16 @@ func findClusterResourceQuota(ctx context.Context, client client.Client, namespa
 // excluding the resource quota being worked on
 func findMatchingResourceQuotas(ctx context.Context, c client.Client, crq *platformv1.ClusterResourceQuota, existing *corev1.ResourceQuota) ([]corev1.ResourceQuota, error) {
 	var namespaces v1.NamespaceList
-	if err := c.List(ctx, &namespaces, client.MatchingLabels(crq.Spec.MatchLabels)); err != nil {
+	if err := c.List(ctx, &namespaces); err != nil {
 		return nil, err
 	}
 
 	resources := []corev1.ResourceQuota{}
 
 	for _, namespace := range namespaces.Items {
+		if !matches(namespace, crq) {
+			continue
+		}
 		namespaceName := namespace.Name
 		rqList := &corev1.ResourceQuotaList{}
 		if err := c.List(ctx, rqList, client.InNamespace(namespaceName)); err != nil {
@@ -92,7 +95,9 @@ func sumByNamespace(list []corev1.ResourceQuota) (map[string]corev1.ResourceQuot
 		}
 		resource := sum[item.GetNamespace()]
 		resource.Spec.Hard = utilquota.Add(resource.Spec.Hard, item.Spec.Hard)
+		resource.Status.Hard = utilquota.Add(resource.Status.Hard, item.Spec.Hard)
 		resource.Status.Used = utilquota.Add(resource.Status.Used, item.Status.Used)
+		sum[item.GetNamespace()] = resource
 	}
 	sort.Strings(keys)
 	return sum, keys
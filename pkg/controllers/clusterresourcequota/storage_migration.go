@@ -0,0 +1,114 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterresourcequota
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	platformv2 "github.com/flanksource/platform-operator/pkg/apis/platform/v2"
+	"github.com/pkg/errors"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const crdName = "clusterresourcequotas.platform.flanksource.com"
+
+// AddStorageVersionMigration rewrites ClusterResourceQuotas stored in an older version using the current storage
+// version once the manager becomes leader, and then drops the older versions from the CRD storedVersions
+func AddStorageVersionMigration(mgr manager.Manager) error {
+	return mgr.Add(&storageVersionMigration{
+		Client: mgr.GetClient(),
+		reader: mgr.GetAPIReader(),
+	})
+}
+
+type storageVersionMigration struct {
+	client.Client
+	reader client.Reader
+}
+
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions/status,verbs=update
+
+func (m *storageVersionMigration) Start(ctx context.Context) error {
+	if err := m.migrate(ctx); err != nil {
+		// objects stored in old versions are still served through conversion, so this is not fatal
+		log.Error(err, "storage version migration failed")
+	}
+	return nil
+}
+
+func (m *storageVersionMigration) migrate(ctx context.Context) error {
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := m.reader.Get(ctx, types.NamespacedName{Name: crdName}, crd); err != nil {
+		return errors.Wrapf(err, "failed to get CRD %s", crdName)
+	}
+
+	storageVersion := ""
+	for _, version := range crd.Spec.Versions {
+		if version.Storage {
+			storageVersion = version.Name
+		}
+	}
+	if storageVersion != platformv2.GroupVersion.Version {
+		log.Info("Skipping storage version migration, CRD has not been updated", "storageVersion", storageVersion)
+		return nil
+	}
+	if len(crd.Status.StoredVersions) == 1 && crd.Status.StoredVersions[0] == storageVersion {
+		return nil
+	}
+
+	quotaList := &platformv2.ClusterResourceQuotaList{}
+	if err := m.reader.List(ctx, quotaList); err != nil {
+		return err
+	}
+	failed := []string{}
+	for _, quota := range quotaList.Items {
+		// an unchanged update is enough for the API server to re-encode the object in the storage version
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			latest := &platformv2.ClusterResourceQuota{}
+			if err := m.reader.Get(ctx, types.NamespacedName{Name: quota.Name}, latest); err != nil {
+				return err
+			}
+			return m.Update(ctx, latest)
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.Error(err, "failed to migrate ClusterResourceQuota", "name", quota.Name)
+			failed = append(failed, quota.Name)
+		}
+	}
+	if len(failed) > 0 {
+		// the quotas that failed may still be stored in an older version, which must then remain in storedVersions
+		return fmt.Errorf("failed to migrate ClusterResourceQuotas %s, keeping storedVersions %v", strings.Join(failed, ", "), crd.Status.StoredVersions)
+	}
+
+	log.Info("Migrated ClusterResourceQuotas to storage version", "version", storageVersion, "count", len(quotaList.Items), "storedVersions", crd.Status.StoredVersions)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := m.reader.Get(ctx, types.NamespacedName{Name: crdName}, crd); err != nil {
+			return err
+		}
+		crd.Status.StoredVersions = []string{storageVersion}
+		return m.Status().Update(ctx, crd)
+	})
+}
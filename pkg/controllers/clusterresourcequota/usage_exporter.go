@@ -0,0 +1,314 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterresourcequota
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	// snapshotKeyFormat is used for file names and ConfigMap keys, it sorts chronologically
+	snapshotKeyFormat = "20060102T150405Z"
+
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// UsageRecord is the hard and used value of a single resource in a namespace matched by a ClusterResourceQuota
+type UsageRecord struct {
+	Timestamp time.Time           `json:"timestamp"`
+	Quota     string              `json:"quota"`
+	Namespace string              `json:"namespace"`
+	Resource  corev1.ResourceName `json:"resource"`
+	Hard      string              `json:"hard"`
+	Used      string              `json:"used"`
+}
+
+// UsageSnapshot is the usage of all ClusterResourceQuotas at a point in time
+type UsageSnapshot struct {
+	Timestamp time.Time     `json:"timestamp"`
+	Records   []UsageRecord `json:"records"`
+}
+
+// UsageSink persists usage snapshots
+type UsageSink interface {
+	Write(ctx context.Context, snapshot UsageSnapshot) error
+}
+
+// AddUsageExporter periodically exports ClusterResourceQuota usage to the sink, it only runs on the leader
+func AddUsageExporter(mgr manager.Manager, interval time.Duration, sink UsageSink) error {
+	exporter := &usageExporter{
+		Client:   mgr.GetClient(),
+		sink:     sink,
+		interval: interval,
+	}
+	return mgr.Add(exporter)
+}
+
+type usageExporter struct {
+	client.Client
+	sink     UsageSink
+	interval time.Duration
+}
+
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;create;update
+
+func (e *usageExporter) Start(ctx context.Context) error {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		if err := e.export(ctx); err != nil {
+			log.Error(err, "failed to export usage snapshot")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *usageExporter) export(ctx context.Context) error {
+	snapshot, err := collectUsage(ctx, e.Client, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	log.V(1).Info("Exporting usage snapshot", "records", len(snapshot.Records))
+	return e.sink.Write(ctx, *snapshot)
+}
+
+func collectUsage(ctx context.Context, c client.Client, now time.Time) (*UsageSnapshot, error) {
+	quotaList := &platformv1.ClusterResourceQuotaList{}
+	if err := c.List(ctx, quotaList); err != nil {
+		return nil, err
+	}
+
+	snapshot := &UsageSnapshot{Timestamp: now, Records: []UsageRecord{}}
+	for _, quota := range quotaList.Items {
+		existing, err := findMatchingResourceQuotas(ctx, c, &quota, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to find resource quotas for %s", quota.Name)
+		}
+		sum, keys := sumByNamespace(existing)
+		for _, namespace := range keys {
+			snapshot.Records = append(snapshot.Records, usageRecords(now, quota.Name, namespace, sum[namespace].Status)...)
+		}
+	}
+	return snapshot, nil
+}
+
+func usageRecords(now time.Time, quota, namespace string, status corev1.ResourceQuotaStatus) []UsageRecord {
+	names := map[corev1.ResourceName]bool{}
+	for name := range status.Hard {
+		names[name] = true
+	}
+	for name := range status.Used {
+		names[name] = true
+	}
+	sorted := []string{}
+	for name := range names {
+		sorted = append(sorted, string(name))
+	}
+	sort.Strings(sorted)
+
+	records := []UsageRecord{}
+	for _, name := range sorted {
+		resource := corev1.ResourceName(name)
+		record := UsageRecord{
+			Timestamp: now,
+			Quota:     quota,
+			Namespace: namespace,
+			Resource:  resource,
+		}
+		if hard, ok := status.Hard[resource]; ok {
+			record.Hard = qtyString(hard)
+		}
+		if used, ok := status.Used[resource]; ok {
+			record.Used = qtyString(used)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// encodeSnapshot returns the snapshot in the given format together with its content type
+func encodeSnapshot(snapshot UsageSnapshot, format string) ([]byte, string, error) {
+	switch format {
+	case FormatJSON:
+		data, err := json.Marshal(snapshot)
+		return data, "application/json", err
+	case FormatCSV:
+		buf := &bytes.Buffer{}
+		w := csv.NewWriter(buf)
+		if err := w.Write([]string{"timestamp", "quota", "namespace", "resource", "hard", "used"}); err != nil {
+			return nil, "", err
+		}
+		for _, r := range snapshot.Records {
+			if err := w.Write([]string{r.Timestamp.Format(time.RFC3339), r.Quota, r.Namespace, string(r.Resource), r.Hard, r.Used}); err != nil {
+				return nil, "", err
+			}
+		}
+		w.Flush()
+		return buf.Bytes(), "text/csv", w.Error()
+	}
+	return nil, "", fmt.Errorf("unsupported export format: %s", format)
+}
+
+// NewUsageSink creates a sink from a URL, one of file:///path/to/dir, configmap://namespace/name
+// (keeping the last <history> snapshots) or http(s)://host/path
+func NewUsageSink(c client.Client, reader client.Reader, sink, format string, history int) (UsageSink, error) {
+	if format != FormatJSON && format != FormatCSV {
+		return nil, fmt.Errorf("unsupported export format: %s", format)
+	}
+	u, err := url.Parse(sink)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid export sink %s", sink)
+	}
+	switch u.Scheme {
+	case "file":
+		return &fileSink{dir: u.Path, format: format}, nil
+	case "configmap":
+		name := strings.Trim(u.Path, "/")
+		if u.Host == "" || name == "" {
+			return nil, fmt.Errorf("configmap export sink must be configmap://namespace/name: %s", sink)
+		}
+		if history < 1 {
+			return nil, fmt.Errorf("export history must be at least 1: %d", history)
+		}
+		return &configMapSink{
+			Client:  c,
+			reader:  reader,
+			key:     types.NamespacedName{Namespace: u.Host, Name: name},
+			format:  format,
+			history: history,
+		}, nil
+	case "http", "https":
+		return &httpSink{url: sink, format: format, client: &http.Client{Timeout: 30 * time.Second}}, nil
+	}
+	return nil, fmt.Errorf("unsupported export sink: %s", sink)
+}
+
+type fileSink struct {
+	dir    string
+	format string
+}
+
+func (s *fileSink) Write(ctx context.Context, snapshot UsageSnapshot) error {
+	data, _, err := encodeSnapshot(snapshot, s.format)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, fmt.Sprintf("usage-%s.%s", snapshot.Timestamp.Format(snapshotKeyFormat), s.format))
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+type configMapSink struct {
+	client.Client
+	reader  client.Reader
+	key     types.NamespacedName
+	format  string
+	history int
+}
+
+func (s *configMapSink) Write(ctx context.Context, snapshot UsageSnapshot) error {
+	data, _, err := encodeSnapshot(snapshot, s.format)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s.%s", snapshot.Timestamp.Format(snapshotKeyFormat), s.format)
+
+	cm := &corev1.ConfigMap{}
+	if err := s.reader.Get(ctx, s.key, cm); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		cm.Namespace = s.key.Namespace
+		cm.Name = s.key.Name
+		cm.Data = map[string]string{key: string(data)}
+		return s.Create(ctx, cm)
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[key] = string(data)
+	trimHistory(cm.Data, s.history)
+	return s.Update(ctx, cm)
+}
+
+// trimHistory removes the oldest snapshots until at most history remain
+func trimHistory(data map[string]string, history int) {
+	keys := []string{}
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for i := 0; i < len(keys)-history; i++ {
+		delete(data, keys[i])
+	}
+}
+
+type httpSink struct {
+	url    string
+	format string
+	client *http.Client
+}
+
+func (s *httpSink) Write(ctx context.Context, snapshot UsageSnapshot) error {
+	data, contentType, err := encodeSnapshot(snapshot, s.format)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("export to %s failed: %s", s.url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterresourcequota
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSumByNamespace(t *testing.T) {
+	quotas := []corev1.ResourceQuota{
+		newResourceQuota("a", "1", "100m"),
+		newResourceQuota("a", "2", "200m"),
+		newResourceQuota("b", "1", "0"),
+	}
+
+	sum, keys := sumByNamespace(quotas)
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Fatalf("unexpected namespaces: %v", keys)
+	}
+	hard := sum["a"].Status.Hard[corev1.ResourceCPU]
+	used := sum["a"].Status.Used[corev1.ResourceCPU]
+	if hard.String() != "3" || used.String() != "300m" {
+		t.Errorf("expected 3/300m, got %s/%s", hard.String(), used.String())
+	}
+}
+
+func TestEncodeSnapshotCSV(t *testing.T) {
+	now := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	snapshot := UsageSnapshot{
+		Timestamp: now,
+		Records:   usageRecords(now, "team-a", "ns1", newResourceQuota("ns1", "2", "500m").Status),
+	}
+
+	data, contentType, err := encodeSnapshot(snapshot, FormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "timestamp,quota,namespace,resource,hard,used\n" +
+		"2021-03-01T00:00:00Z,team-a,ns1,cpu,2,500m\n"
+	if string(data) != expected || contentType != "text/csv" {
+		t.Errorf("unexpected csv:\n%s", string(data))
+	}
+}
+
+func TestUsageExporterStart(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = platformv1.AddToScheme(scheme)
+	rq := newResourceQuota("ns1", "2", "500m")
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1", Labels: map[string]string{"team": "a"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns2"}},
+		&rq,
+		&platformv1.ClusterResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "team-a"},
+			Spec:       platformv1.ClusterResourceQuotaSpec{MatchLabels: map[string]string{"team": "a"}},
+		},
+	).Build()
+
+	dir := t.TempDir()
+	sink, err := NewUsageSink(c, c, "file://"+dir, FormatJSON, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter := &usageExporter{Client: c, sink: sink, interval: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	// a cancelled context exports a single snapshot before returning
+	cancel()
+	if err := exporter.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "usage-*.json"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected a single snapshot, got %v, %v", files, err)
+	}
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := UsageSnapshot{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Records) != 1 || snapshot.Records[0].Quota != "team-a" || snapshot.Records[0].Namespace != "ns1" || snapshot.Records[0].Used != "500m" {
+		t.Errorf("unexpected snapshot: %+v", snapshot.Records)
+	}
+}
+
+func TestTrimHistory(t *testing.T) {
+	data := map[string]string{
+		"20210101T000000Z.json": "",
+		"20210102T000000Z.json": "",
+		"20210103T000000Z.json": "",
+	}
+	trimHistory(data, 2)
+	if _, ok := data["20210101T000000Z.json"]; ok || len(data) != 2 {
+		t.Errorf("expected oldest snapshot to be removed: %v", data)
+	}
+}
+
+func newResourceQuota(namespace, hard, used string) corev1.ResourceQuota {
+	return corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "rq"},
+		Spec: corev1.ResourceQuotaSpec{
+			Hard: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(hard)},
+		},
+		Status: corev1.ResourceQuotaStatus{
+			Hard: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(hard)},
+			Used: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(used)},
+		},
+	}
+}
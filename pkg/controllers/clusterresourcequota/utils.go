@@ -16,19 +16,27 @@ limitations under the License.
 package clusterresourcequota
 
 import (
+	"sort"
+
 	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 )
 
+// matches is the only rule deciding whether a quota governs a namespace: a quota with allNamespaces matches
+// every namespace, otherwise all of its (non-empty) matchLabels must be present on the namespace
 func matches(namespace corev1.Namespace, quota *platformv1.ClusterResourceQuota) bool {
+	if quota.Spec.AllNamespaces {
+		return true
+	}
+
 	if len(quota.Spec.MatchLabels) == 0 {
 		return false
 	}
 
 	for k, v := range quota.Spec.MatchLabels {
-		if namespace.GetLabels()[k] != v {
+		if value, ok := namespace.GetLabels()[k]; !ok || value != v {
 			return false
 		}
 	}
@@ -38,3 +46,18 @@ func matches(namespace corev1.Namespace, quota *platformv1.ClusterResourceQuota)
 func qtyString(v resource.Quantity) string {
 	return v.String()
 }
+
+// sortedResourceNames returns the resource names of a list in a stable order
+func sortedResourceNames(list corev1.ResourceList) []corev1.ResourceName {
+	names := []string{}
+	for name := range list {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+
+	result := []corev1.ResourceName{}
+	for _, name := range names {
+		result = append(result, corev1.ResourceName(name))
+	}
+	return result
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterresourcequota
+
+import (
+	"sort"
+	"strings"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// standardQuotaResources are the resource names a ResourceQuota accepts without a prefix
+var standardQuotaResources = map[corev1.ResourceName]bool{
+	corev1.ResourceCPU:                      true,
+	corev1.ResourceMemory:                   true,
+	corev1.ResourceEphemeralStorage:         true,
+	corev1.ResourceRequestsCPU:              true,
+	corev1.ResourceRequestsMemory:           true,
+	corev1.ResourceRequestsStorage:          true,
+	corev1.ResourceRequestsEphemeralStorage: true,
+	corev1.ResourceLimitsCPU:                true,
+	corev1.ResourceLimitsMemory:             true,
+	corev1.ResourceLimitsEphemeralStorage:   true,
+	corev1.ResourcePods:                     true,
+	corev1.ResourceServices:                 true,
+	corev1.ResourceReplicationControllers:   true,
+	corev1.ResourceQuotas:                   true,
+	corev1.ResourceSecrets:                  true,
+	corev1.ResourceConfigMaps:               true,
+	corev1.ResourcePersistentVolumeClaims:   true,
+	corev1.ResourceServicesNodePorts:        true,
+	corev1.ResourceServicesLoadBalancers:    true,
+}
+
+// validateSpec returns all problems with a ClusterResourceQuota spec
+func validateSpec(crq *platformv1.ClusterResourceQuota) field.ErrorList {
+	errs := field.ErrorList{}
+	spec := field.NewPath("spec")
+
+	if crq.Spec.AllNamespaces && len(crq.Spec.MatchLabels) > 0 {
+		errs = append(errs, field.Invalid(spec.Child("matchLabels"), crq.Spec.MatchLabels, "must be empty when allNamespaces is true"))
+	} else if !crq.Spec.AllNamespaces && len(crq.Spec.MatchLabels) == 0 {
+		errs = append(errs, field.Required(spec.Child("matchLabels"), "must select at least one label, set allNamespaces to apply the quota to every namespace"))
+	}
+
+	keys := []string{}
+	for k := range crq.Spec.MatchLabels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := crq.Spec.MatchLabels[k]
+		for _, msg := range validation.IsQualifiedName(k) {
+			errs = append(errs, field.Invalid(spec.Child("matchLabels"), k, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(v) {
+			errs = append(errs, field.Invalid(spec.Child("matchLabels").Key(k), v, msg))
+		}
+	}
+
+	for _, name := range sortedResourceNames(crq.Spec.Hard) {
+		qty := crq.Spec.Hard[name]
+		path := spec.Child("hard").Key(string(name))
+		if !isQuotaResourceName(name) {
+			errs = append(errs, field.Invalid(path, name, "not a resource name that can be enforced by a ResourceQuota"))
+		}
+		if qty.Sign() < 0 {
+			errs = append(errs, field.Invalid(path, qtyString(qty), "must be greater than or equal to 0"))
+		}
+	}
+	if reclaim := crq.Spec.Reclaim; reclaim != nil {
+		path := spec.Child("reclaim")
+		if reclaim.ThresholdPercent < 1 || reclaim.ThresholdPercent > 100 {
+			errs = append(errs, field.Invalid(path.Child("thresholdPercent"), reclaim.ThresholdPercent, "must be between 1 and 100"))
+		}
+		if reclaim.HeadroomPercent < 0 {
+			errs = append(errs, field.Invalid(path.Child("headroomPercent"), reclaim.HeadroomPercent, "must be greater than or equal to 0"))
+		}
+		if reclaim.Period.Duration <= 0 {
+			errs = append(errs, field.Invalid(path.Child("period"), reclaim.Period.Duration.String(), "must be greater than 0"))
+		}
+		for i, name := range reclaim.Resources {
+			if _, ok := crq.Spec.Hard[name]; !ok {
+				errs = append(errs, field.Invalid(path.Child("resources").Index(i), name, "must be one of spec.hard"))
+			}
+		}
+	}
+	return errs
+}
+
+// isQuotaResourceName mirrors the names accepted by the API server for ResourceQuotas: a standard or
+// hugepages resource, or a qualified name such as count/deployments.apps or requests.nvidia.com/gpu
+func isQuotaResourceName(name corev1.ResourceName) bool {
+	s := string(name)
+	switch {
+	case standardQuotaResources[name]:
+		return true
+	case strings.HasPrefix(s, corev1.ResourceHugePagesPrefix), strings.HasPrefix(s, corev1.ResourceRequestsHugePagesPrefix):
+		return true
+	case strings.Contains(s, "/"):
+		return len(validation.IsQualifiedName(s)) == 0
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterresourcequota
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func newQuota(all bool, matchLabels map[string]string, hard corev1.ResourceList) *platformv1.ClusterResourceQuota {
+	return &platformv1.ClusterResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "crq"},
+		Spec: platformv1.ClusterResourceQuotaSpec{
+			AllNamespaces:     all,
+			MatchLabels:       matchLabels,
+			ResourceQuotaSpec: corev1.ResourceQuotaSpec{Hard: hard},
+		},
+	}
+}
+
+func TestValidateSpec(t *testing.T) {
+	cpu := corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("1")}
+	fixtures := map[string]struct {
+		quota *platformv1.ClusterResourceQuota
+		valid bool
+	}{
+		"labels":                {newQuota(false, map[string]string{"team": "a"}, cpu), true},
+		"all namespaces":        {newQuota(true, nil, cpu), true},
+		"empty selector":        {newQuota(false, nil, cpu), false},
+		"labels and all":        {newQuota(true, map[string]string{"team": "a"}, cpu), false},
+		"invalid label":         {newQuota(false, map[string]string{"team": "a b"}, cpu), false},
+		"negative quantity":     {newQuota(false, map[string]string{"team": "a"}, corev1.ResourceList{corev1.ResourcePods: resource.MustParse("-1")}), false},
+		"unknown resource":      {newQuota(false, map[string]string{"team": "a"}, corev1.ResourceList{"cpus": resource.MustParse("1")}), false},
+		"object count resource": {newQuota(false, map[string]string{"team": "a"}, corev1.ResourceList{"count/deployments.apps": resource.MustParse("1")}), true},
+		"extended resource":     {newQuota(false, map[string]string{"team": "a"}, corev1.ResourceList{"requests.nvidia.com/gpu": resource.MustParse("1")}), true},
+	}
+
+	for name, fixture := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			errs := validateSpec(fixture.quota)
+			if fixture.valid && len(errs) > 0 {
+				t.Errorf("expected valid, got %v", errs)
+			} else if !fixture.valid && len(errs) == 0 {
+				t.Errorf("expected invalid")
+			}
+		})
+	}
+}
+
+func TestValidatingWebhookUnchangedSpec(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = platformv1.AddToScheme(scheme)
+	webhook := NewClusterResourceQuotaValidatingWebhook(fake.NewClientBuilder().WithScheme(scheme).Build(), &sync.Mutex{}, true)
+	cpu := corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("1")}
+
+	request := func(operation admissionv1.Operation, old, quota *platformv1.ClusterResourceQuota) admission.Request {
+		req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Operation: operation, Name: quota.Name}}
+		req.Object.Raw, _ = json.Marshal(quota)
+		if old != nil {
+			req.OldObject.Raw, _ = json.Marshal(old)
+		}
+		return req
+	}
+	// an empty selector was accepted before specs were validated
+	legacy := newQuota(false, nil, cpu)
+	relabelled := legacy.DeepCopy()
+	relabelled.Labels = map[string]string{"team": "a"}
+	if resp := webhook.Handle(context.Background(), request(admissionv1.Update, legacy, relabelled)); !resp.Allowed {
+		t.Errorf("expected an update not changing the spec to be allowed, got %v", resp.Result)
+	}
+	changed := legacy.DeepCopy()
+	changed.Spec.Hard = corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("2")}
+	if resp := webhook.Handle(context.Background(), request(admissionv1.Update, legacy, changed)); resp.Allowed {
+		t.Error("expected an update changing an invalid spec to be denied")
+	}
+	if resp := webhook.Handle(context.Background(), request(admissionv1.Create, nil, legacy)); resp.Allowed {
+		t.Error("expected an invalid spec to be denied on creation")
+	}
+}
+
+func TestMatches(t *testing.T) {
+	namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns", Labels: map[string]string{"team": "a"}}}
+	other := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+
+	if matches(namespace, newQuota(false, nil, nil)) {
+		t.Error("an empty selector should not match any namespace")
+	}
+	if !matches(other, newQuota(true, nil, nil)) {
+		t.Error("allNamespaces should match every namespace")
+	}
+	if !matches(namespace, newQuota(false, map[string]string{"team": "a"}, nil)) || matches(other, newQuota(false, map[string]string{"team": "a"}, nil)) {
+		t.Error("matchLabels should only match labelled namespaces")
+	}
+	if matches(other, newQuota(false, map[string]string{"team": ""}, nil)) {
+		t.Error("an empty label value should not match a missing label")
+	}
+}
@@ -0,0 +1,24 @@
+package inheritance
+
+import (
+	"time"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	"github.com/pkg/errors"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	name = "inheritance-controller"
+)
+
+var log = logf.Log.WithName(name)
+
+// Add creates the controller backfilling the inherited labels of existing resources of the configured kinds
+func Add(mgr manager.Manager, interval time.Duration, cfg platformv1.InheritanceConfig) error {
+	if err := addNamespaceReconciler(mgr, newNamespaceReconciler(mgr, interval, cfg)); err != nil {
+		return errors.Wrap(err, "failed to add namespace reconciler")
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package inheritance
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Kinds are the resources namespace labels can be inherited by and the version they are listed with. Pods are mutated
+// by the pod mutator instead.
+var Kinds = map[string]schema.GroupVersionKind{
+	"pods":                   {Version: "v1", Kind: "Pod"},
+	"persistentvolumeclaims": {Version: "v1", Kind: "PersistentVolumeClaim"},
+	"services":               {Version: "v1", Kind: "Service"},
+	"deployments":            {Group: "apps", Version: "v1", Kind: "Deployment"},
+	"statefulsets":           {Group: "apps", Version: "v1", Kind: "StatefulSet"},
+	"daemonsets":             {Group: "apps", Version: "v1", Kind: "DaemonSet"},
+	"replicasets":            {Group: "apps", Version: "v1", Kind: "ReplicaSet"},
+	"jobs":                   {Group: "batch", Version: "v1", Kind: "Job"},
+	"cronjobs":               {Group: "batch", Version: "v1beta1", Kind: "CronJob"},
+}
+
+// ParseKinds parses a comma separated list of the resources of Kinds
+func ParseKinds(value string) ([]string, error) {
+	kinds := []string{}
+	for _, kind := range strings.Split(value, ",") {
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		if kind == "" {
+			continue
+		}
+		if _, ok := Kinds[kind]; !ok {
+			supported := []string{}
+			for k := range Kinds {
+				supported = append(supported, k)
+			}
+			sort.Strings(supported)
+			return nil, errors.Errorf("unsupported kind %s, expected one of %s", kind, strings.Join(supported, ","))
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds, nil
+}
+
+// InheritLabels adds the namespace labels starting with one of the prefixes to labels, labels already set are not
+// overridden. It returns the labels and whether any were added.
+func InheritLabels(prefixes []string, namespace, labels map[string]string) (map[string]string, bool) {
+	changed := false
+	for key, value := range namespace {
+		if !hasPrefix(prefixes, key) {
+			continue
+		}
+		if _, exists := labels[key]; exists {
+			continue
+		}
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[key] = value
+		changed = true
+	}
+	return labels, changed
+}
+
+func hasPrefix(prefixes []string, key string) bool {
+	for _, prefix := range prefixes {
+		if prefix != "" && strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package inheritance
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInheritLabels(t *testing.T) {
+	namespace := map[string]string{"team": "payments", "cost-center": "cc-42", "kubernetes.io/metadata.name": "payments"}
+	prefixes := []string{"team", "cost-center", ""}
+
+	labels, changed := InheritLabels(prefixes, namespace, nil)
+	if !changed || !reflect.DeepEqual(labels, map[string]string{"team": "payments", "cost-center": "cc-42"}) {
+		t.Errorf("expected the whitelisted labels to be inherited, got %v", labels)
+	}
+
+	labels, changed = InheritLabels(prefixes, namespace, map[string]string{"team": "platform", "cost-center": "cc-42"})
+	if changed || labels["team"] != "platform" {
+		t.Errorf("expected labels already set not to be overridden, got %v", labels)
+	}
+}
+
+func TestParseKinds(t *testing.T) {
+	kinds, err := ParseKinds("Pods, services,,cronjobs")
+	if err != nil || !reflect.DeepEqual(kinds, []string{"pods", "services", "cronjobs"}) {
+		t.Errorf("expected pods,services,cronjobs, got %v %v", kinds, err)
+	}
+	if _, err := ParseKinds("services,ingresses"); err == nil {
+		t.Error("expected ingresses to be unsupported")
+	}
+}
@@ -0,0 +1,72 @@
+package inheritance
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+type labelsHandler struct {
+	Client client.Client
+	Log    logr.Logger
+	platformv1.InheritanceConfig
+}
+
+//+kubebuilder:webhook:path=/mutate-inherited-labels,mutating=true,sideEffects=None,admissionReviewVersions=v1,failurePolicy=ignore,groups="";apps;batch,resources=persistentvolumeclaims;services;deployments;statefulsets;daemonsets;replicasets;jobs;cronjobs,verbs=create;update,versions=v1;v1beta1,name=mutate-inherited-labels.platform.flanksource.com
+func NewMutatingWebhook(client client.Client, cfg platformv1.InheritanceConfig) *admission.Webhook {
+	return &admission.Webhook{
+		Handler: &labelsHandler{
+			Client:            client,
+			InheritanceConfig: cfg,
+			Log:               logf.Log.WithName("label-inheritance")},
+	}
+}
+
+var _ admission.Handler = &labelsHandler{}
+
+func (handler *labelsHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	// the webhook is registered for all supported kinds, the ones not configured are skipped here
+	if req.SubResource != "" || req.Resource.Resource == "pods" || !contains(handler.Kinds, req.Resource.Resource) {
+		return admission.Allowed("")
+	}
+
+	namespace := corev1.Namespace{}
+	if err := handler.Client.Get(ctx, types.NamespacedName{Name: req.Namespace}, &namespace); err != nil {
+		return admission.Errored(http.StatusInternalServerError, errors.Wrapf(err, "failed to get namespace %s", req.Namespace))
+	}
+
+	obj := &unstructured.Unstructured{}
+	if err := json.Unmarshal(req.Object.Raw, &obj.Object); err != nil {
+		return admission.Errored(http.StatusBadRequest, errors.Wrapf(err, "failed to decode %s", req.Kind.Kind))
+	}
+	labels, changed := InheritLabels(handler.Labels, namespace.Labels, obj.GetLabels())
+	if !changed {
+		return admission.Allowed("")
+	}
+	obj.SetLabels(labels)
+
+	marshaled, err := json.Marshal(obj.Object)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, errors.Wrapf(err, "Failed to marshal %s", req.Kind.Kind))
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
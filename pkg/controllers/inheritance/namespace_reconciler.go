@@ -0,0 +1,101 @@
+package inheritance
+
+import (
+	"context"
+	"time"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	perrors "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// NamespaceReconciler periodically adds the labels inherited from a namespace to the existing resources in it, which
+// the webhook only labels when they are created or updated
+type NamespaceReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// reader lists the resources from the API server, as the cached client would start an informer caching every
+	// resource of the configured kinds in the cluster
+	reader client.Reader
+	// interval is the time after which the controller requeue the reconcile key
+	interval time.Duration
+	cfg      platformv1.InheritanceConfig
+}
+
+func newNamespaceReconciler(mgr manager.Manager, interval time.Duration, cfg platformv1.InheritanceConfig) reconcile.Reconciler {
+	return &NamespaceReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		reader:   mgr.GetAPIReader(),
+		interval: interval,
+		cfg:      cfg,
+	}
+}
+
+func addNamespaceReconciler(mgr manager.Manager, r reconcile.Reconciler) error {
+	c, err := controller.New(name, mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	return c.Watch(&source.Kind{Type: &corev1.Namespace{}}, &handler.EnqueueRequestForObject{})
+}
+
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims;services,verbs=get;list;patch
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets;replicasets,verbs=get;list;patch
+// +kubebuilder:rbac:groups=batch,resources=jobs;cronjobs,verbs=get;list;patch
+
+func (r *NamespaceReconciler) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	ns := corev1.Namespace{}
+	if err := r.Get(ctx, request.NamespacedName, &ns); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	for _, kind := range r.cfg.Kinds {
+		if kind == "pods" {
+			continue
+		}
+		if err := r.inherit(ctx, ns, kind); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+	return reconcile.Result{RequeueAfter: r.interval}, nil
+}
+
+// inherit patches the resources of the kind in the namespace missing inherited labels
+func (r *NamespaceReconciler) inherit(ctx context.Context, ns corev1.Namespace, kind string) error {
+	gvk := Kinds[kind]
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	if err := r.reader.List(ctx, list, client.InNamespace(ns.Name)); err != nil {
+		return perrors.Wrapf(err, "failed to list %s in namespace %s", kind, ns.Name)
+	}
+	for i := range list.Items {
+		item := &list.Items[i]
+		patch := client.MergeFrom(item.DeepCopy())
+		labels, changed := InheritLabels(r.cfg.Labels, ns.Labels, item.GetLabels())
+		if !changed {
+			continue
+		}
+		item.SetLabels(labels)
+		if err := r.Patch(ctx, item, patch); err != nil {
+			log.Error(err, "failed to update", "kind", gvk.Kind, "namespace", ns.Name, "name", item.GetName())
+			return err
+		}
+		log.Info("Updated", "kind", gvk.Kind, "namespace", ns.Name, "name", item.GetName())
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package inheritance
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var cfg = platformv1.InheritanceConfig{Labels: []string{"team", "cost-center"}, Kinds: []string{"pods", "services", "deployments"}}
+
+func newNamespace() *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments", Labels: map[string]string{"team": "payments", "cost-center": "cc-42"}}}
+}
+
+func TestReconcile(t *testing.T) {
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "payments", Labels: map[string]string{"team": "platform"}}}
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "payments"}}
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "payments"}}
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(newNamespace(), svc, deployment, pvc).Build()
+	r := &NamespaceReconciler{Client: c, reader: c, cfg: cfg}
+	ctx := context.Background()
+
+	if _, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Name: "payments"}}); err != nil {
+		t.Fatal(err)
+	}
+	key := types.NamespacedName{Namespace: "payments", Name: "api"}
+	if err := c.Get(ctx, key, svc); err != nil || svc.Labels["team"] != "platform" || svc.Labels["cost-center"] != "cc-42" {
+		t.Errorf("expected the service to inherit the missing labels, got %v %v", svc.Labels, err)
+	}
+	if err := c.Get(ctx, key, deployment); err != nil || deployment.Labels["team"] != "payments" {
+		t.Errorf("expected the deployment to inherit the labels, got %v %v", deployment.Labels, err)
+	}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "payments", Name: "data"}, pvc); err != nil || len(pvc.Labels) != 0 {
+		t.Errorf("expected kinds not configured not to be labelled, got %v %v", pvc.Labels, err)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(newNamespace()).Build()
+	handler := &labelsHandler{Client: c, Log: logf.Log, InheritanceConfig: cfg}
+	request := func(resource string, obj runtime.Object) admission.Request {
+		raw, _ := json.Marshal(obj)
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Namespace: "payments",
+			Resource:  metav1.GroupVersionResource{Version: "v1", Resource: resource},
+			Object:    runtime.RawExtension{Raw: raw},
+		}}
+	}
+
+	response := handler.Handle(context.Background(), request("services", &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "api"}}))
+	if !response.Allowed || len(response.Patches) != 1 || response.Patches[0].Path != "/metadata/labels" {
+		t.Errorf("expected the labels to be added, got %v %v", response.Patches, response.Result)
+	}
+	response = handler.Handle(context.Background(), request("persistentvolumeclaims", &corev1.PersistentVolumeClaim{}))
+	if !response.Allowed || len(response.Patches) != 0 {
+		t.Errorf("expected kinds not configured not to be patched, got %v", response.Patches)
+	}
+}
@@ -0,0 +1,243 @@
+package pod
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// maxAnnotationTemplateOutput bounds the rendered value, the total size of the annotations of an object is 256KiB
+	maxAnnotationTemplateOutput = 64 * 1024
+	// annotationTemplateTimeout bounds the time rendering a value, within the timeout of the admission request
+	annotationTemplateTimeout = 100 * time.Millisecond
+	// platformAnnotationPrefix is the prefix of the annotations the operator sets on pods
+	platformAnnotationPrefix = "platform.flanksource.com/"
+)
+
+// annotationTemplateFuncs are the builtin functions templates can use. Functions calling arbitrary values (call) or
+// allocating unbounded output before it is limited (printf) are not available.
+var annotationTemplateFuncs = map[string]bool{
+	"and": true, "or": true, "not": true, "eq": true, "ne": true, "lt": true, "le": true, "gt": true, "ge": true,
+	"index": true, "len": true,
+}
+
+// annotationTemplateData is available to inherited annotation templates. Only plain copies of the metadata are exposed
+// so that templates cannot call methods of the API objects.
+type annotationTemplateData struct {
+	Namespace templateObject
+	Pod       templatePod
+}
+
+type templateObject struct {
+	Name         string
+	GenerateName string
+	Namespace    string
+	Labels       map[string]string
+	Annotations  map[string]string
+}
+
+func newTemplateObject(meta metav1.Object) templateObject {
+	return templateObject{
+		Name:         meta.GetName(),
+		GenerateName: meta.GetGenerateName(),
+		Namespace:    meta.GetNamespace(),
+		Labels:       copyMap(meta.GetLabels()),
+		Annotations:  copyMap(meta.GetAnnotations()),
+	}
+}
+
+// templatePod is the metadata of the pod as it is both when it is admitted and when it is reconciled, so that a
+// template renders the same value in both. The name and namespace are not available as pods being created often only
+// have a GenerateName, and the inherited and platform.flanksource.com/ annotations are left out as they are only
+// added while admitting the pod.
+type templatePod struct {
+	GenerateName string
+	Labels       map[string]string
+	Annotations  map[string]string
+}
+
+func newTemplatePod(pod *v1.Pod) templatePod {
+	inherited := inheritedAnnotations(pod)
+	annotations := map[string]string{}
+	for k, v := range pod.Annotations {
+		if inherited[k] || strings.HasPrefix(k, platformAnnotationPrefix) {
+			continue
+		}
+		annotations[k] = v
+	}
+	return templatePod{
+		GenerateName: pod.GenerateName,
+		Labels:       copyMap(pod.Labels),
+		Annotations:  annotations,
+	}
+}
+
+// renderAnnotation renders the value of a namespace annotation for the pod when it contains a template, e.g.
+// {{ .Namespace.Name }}-{{ .Pod.Labels.app }}. Missing labels and annotations render as empty strings. Rendering fails
+// once ctx is done or after annotationTemplateTimeout.
+func renderAnnotation(ctx context.Context, value string, ns v1.Namespace, pod *v1.Pod) (string, error) {
+	if !strings.Contains(value, "{{") {
+		return value, nil
+	}
+	tpl, err := template.New("").Option("missingkey=zero").Parse(value)
+	if err != nil {
+		return "", err
+	}
+	if err := validateTemplate(tpl); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, annotationTemplateTimeout)
+	defer cancel()
+	type result struct {
+		value string
+		err   error
+	}
+	// the execution cannot be interrupted, it stops at the next write once ctx is done
+	done := make(chan result, 1)
+	go func() {
+		out := &limitedBuffer{ctx: ctx, limit: maxAnnotationTemplateOutput}
+		err := tpl.Execute(out, annotationTemplateData{Namespace: newTemplateObject(&ns), Pod: newTemplatePod(pod)})
+		done <- result{value: out.String(), err: err}
+	}()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		return "", errors.Wrap(ctx.Err(), "rendering did not complete")
+	}
+}
+
+// validateTemplate rejects templates using functions not in annotationTemplateFuncs, invoking other templates or
+// ranging over anything but the fields of the data, e.g. integers, so that the execution is bounded by the size of the
+// metadata of the namespace and pod
+func validateTemplate(tpl *template.Template) error {
+	if len(tpl.Templates()) > 1 {
+		return errors.New("templates cannot be defined")
+	}
+	var validate func(node parse.Node) error
+	validate = func(node parse.Node) error {
+		switch node := node.(type) {
+		case *parse.ListNode:
+			if node == nil {
+				return nil
+			}
+			for _, n := range node.Nodes {
+				if err := validate(n); err != nil {
+					return err
+				}
+			}
+		case *parse.ActionNode:
+			return validate(node.Pipe)
+		case *parse.IfNode:
+			return validateBranch(validate, &node.BranchNode)
+		case *parse.WithNode:
+			return validateBranch(validate, &node.BranchNode)
+		case *parse.RangeNode:
+			if !isDataField(node.Pipe) {
+				return errors.Errorf("range over %s is not allowed, only over fields like .Pod.Labels", node.Pipe)
+			}
+			return validateBranch(validate, &node.BranchNode)
+		case *parse.TemplateNode:
+			return errors.Errorf("template %q cannot be invoked", node.Name)
+		case *parse.PipeNode:
+			if node == nil {
+				return nil
+			}
+			for _, cmd := range node.Cmds {
+				if err := validate(cmd); err != nil {
+					return err
+				}
+			}
+		case *parse.CommandNode:
+			for _, arg := range node.Args {
+				if err := validate(arg); err != nil {
+					return err
+				}
+			}
+		case *parse.ChainNode:
+			return validate(node.Node)
+		case *parse.IdentifierNode:
+			if !annotationTemplateFuncs[node.Ident] {
+				return errors.Errorf("function %q is not allowed", node.Ident)
+			}
+		}
+		return nil
+	}
+	return validate(tpl.Tree.Root)
+}
+
+func validateBranch(validate func(parse.Node) error, branch *parse.BranchNode) error {
+	for _, node := range []parse.Node{branch.Pipe, branch.List, branch.ElseList} {
+		if err := validate(node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isDataField returns true if the pipeline is a single field of the data, e.g. .Pod.Labels or $.Namespace.Annotations
+func isDataField(pipe *parse.PipeNode) bool {
+	if len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.FieldNode:
+		return true
+	case *parse.VariableNode:
+		return len(arg.Ident) > 1 && arg.Ident[0] == "$"
+	}
+	return false
+}
+
+// limitedBuffer fails writes beyond its limit or once ctx is done, stopping the execution of templates producing
+// unbounded output or running past their deadline
+type limitedBuffer struct {
+	bytes.Buffer
+	ctx   context.Context
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if err := b.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if b.Len()+len(p) > b.limit {
+		return 0, errors.Errorf("rendered value exceeds %d bytes", b.limit)
+	}
+	return b.Buffer.Write(p)
+}
+
+// recordAnnotationErrors records the errors rendering inherited annotations as events on the namespace defining them
+func recordAnnotationErrors(recorder record.EventRecorder, ns v1.Namespace, pod *v1.Pod, errs []error) {
+	for _, err := range errs {
+		log.Error(err, "Not inheriting annotation", "namespace", ns.Name, "pod", podDisplayName(pod))
+		if recorder != nil {
+			recorder.Eventf(&ns, v1.EventTypeWarning, "InvalidAnnotationTemplate", "Pod %s: %s", podDisplayName(pod), err)
+		}
+	}
+}
+
+func podDisplayName(pod *v1.Pod) string {
+	if pod.Name != "" {
+		return pod.Name
+	}
+	return pod.GenerateName
+}
+
+func copyMap(m map[string]string) map[string]string {
+	copied := map[string]string{}
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
@@ -16,12 +16,13 @@ const (
 
 var log = logf.Log.WithName(name)
 
-func Add(mgr manager.Manager, interval time.Duration, cfg platformv1.PodMutaterConfig) error {
-	if err := addPodReconciler(mgr, NewPodReconciler(mgr, cfg)); err != nil {
+// Add creates the controllers inheriting namespace annotations, the hierarchy must be shared with the mutating webhook
+func Add(mgr manager.Manager, interval time.Duration, cfg platformv1.PodMutaterConfig, hierarchy *NamespaceHierarchy) error {
+	if err := addPodReconciler(mgr, NewPodReconciler(mgr, cfg, hierarchy)); err != nil {
 		return errors.Wrap(err, "failed to add pod reconciler")
 	}
 
-	if err := addNamespaceReconciler(mgr, newNamespaceReconciler(mgr, interval, cfg)); err != nil {
+	if err := addNamespaceReconciler(mgr, newNamespaceReconciler(mgr, interval, cfg, hierarchy), hierarchy); err != nil {
 		return errors.Wrap(err, "failed to add namespace reconciler")
 	}
 
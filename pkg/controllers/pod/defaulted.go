@@ -0,0 +1,36 @@
+package pod
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultedAnnotation is set on pods to the fields defaulted by the mutating webhook, as a JSON object keyed by what
+// was defaulted, e.g. {"resources":{"app":{"requests":{"cpu":"100m"}}},"securityContext":["spec.securityContext.runAsNonRoot"]}
+const DefaultedAnnotation = "platform.flanksource.com/defaulted"
+
+// recordDefaulted records the defaulted fields under key in the DefaultedAnnotation of the pod, keeping other keys
+func recordDefaulted(pod *corev1.Pod, key string, defaulted interface{}) error {
+	record := map[string]json.RawMessage{}
+	if value := pod.Annotations[DefaultedAnnotation]; value != "" {
+		// a value not written by the webhook is replaced
+		if err := json.Unmarshal([]byte(value), &record); err != nil {
+			record = map[string]json.RawMessage{}
+		}
+	}
+	value, err := json.Marshal(defaulted)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal defaulted %s", key)
+	}
+	record[key] = value
+	if value, err = json.Marshal(record); err != nil {
+		return errors.Wrap(err, "failed to marshal defaulted fields")
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[DefaultedAnnotation] = string(value)
+	return nil
+}
@@ -0,0 +1,36 @@
+package pod
+
+import (
+	"encoding/json"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultedFields returns the fields recorded in the DefaultedAnnotation of the pod by key
+func defaultedFields(t *testing.T, pod *corev1.Pod) map[string]string {
+	record := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(pod.Annotations[DefaultedAnnotation]), &record); err != nil {
+		t.Fatalf("invalid %s annotation: %v", DefaultedAnnotation, err)
+	}
+	fields := map[string]string{}
+	for key, value := range record {
+		fields[key] = string(value)
+	}
+	return fields
+}
+
+func TestRecordDefaulted(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{DefaultedAnnotation: "invalid"}}}
+	if err := recordDefaulted(pod, "securityContext", []string{"spec.securityContext.runAsNonRoot"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordDefaulted(pod, "resources", map[string]string{"app": "cpu"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"resources":{"app":"cpu"},"securityContext":["spec.securityContext.runAsNonRoot"]}`
+	if value := pod.Annotations[DefaultedAnnotation]; value != expected {
+		t.Errorf("expected both defaults to be recorded in %s, got %s", expected, value)
+	}
+}
@@ -0,0 +1,357 @@
+package pod
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// dockerHubRegistry is the host serving the registry API for docker.io images
+	dockerHubRegistry = "registry-1.docker.io"
+
+	// digestTimeout bounds resolving the digests of all images of a pod, leaving the mutating webhook enough of its 15s
+	// timeoutSeconds to answer the admission request
+	digestTimeout = 10 * time.Second
+
+	// digestCacheSize is the maximum number of cached digests
+	digestCacheSize = 1000
+)
+
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+var challengeParams = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// registryAuth are the credentials for a registry from a docker config pull secret
+type registryAuth struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Auth     string `json:"auth,omitempty"`
+}
+
+type cachedDigest struct {
+	digest  digest.Digest
+	expires time.Time
+}
+
+// digestResolver resolves image tags to the digest of their manifest using the registry API, results are cached for
+// ttl so that creating many pods with the same image does not hit the registry every time
+type digestResolver struct {
+	client *http.Client
+	ttl    time.Duration
+	size   int
+	mtx    sync.Mutex
+	cache  map[string]cachedDigest
+}
+
+func newDigestResolver(client *http.Client, ttl time.Duration) *digestResolver {
+	return &digestResolver{
+		client: client,
+		ttl:    ttl,
+		size:   digestCacheSize,
+		cache:  map[string]cachedDigest{},
+	}
+}
+
+// Pin returns the image pinned to the digest its tag, or latest when untagged, currently points to
+func (r *digestResolver) Pin(ctx context.Context, image string, auths map[string]registryAuth) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return image, errors.Wrapf(err, "invalid image %s", image)
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return image, nil
+	}
+	d, err := r.Resolve(ctx, reference.TagNameOnly(named).(reference.NamedTagged), auths)
+	if err != nil {
+		return image, err
+	}
+	return reference.FamiliarName(named) + "@" + d.String(), nil
+}
+
+// Resolve returns the digest of the manifest the tag of the image points to
+func (r *digestResolver) Resolve(ctx context.Context, image reference.NamedTagged, auths map[string]registryAuth) (digest.Digest, error) {
+	key := image.String()
+	r.mtx.Lock()
+	cached, ok := r.cache[key]
+	if ok && !time.Now().Before(cached.expires) {
+		delete(r.cache, key)
+		ok = false
+	}
+	r.mtx.Unlock()
+	if ok {
+		return cached.digest, nil
+	}
+
+	d, err := r.fetch(ctx, image, auths[reference.Domain(image)])
+	if err != nil {
+		return "", err
+	}
+	r.mtx.Lock()
+	r.store(key, d, time.Now())
+	r.mtx.Unlock()
+	return d, nil
+}
+
+// store caches the digest, once the cache is full expired digests are evicted first and then those expiring first
+func (r *digestResolver) store(key string, d digest.Digest, now time.Time) {
+	if len(r.cache) >= r.size {
+		for k, cached := range r.cache {
+			if !now.Before(cached.expires) {
+				delete(r.cache, k)
+			}
+		}
+	}
+	for len(r.cache) >= r.size {
+		oldest := ""
+		for k, cached := range r.cache {
+			if oldest == "" || cached.expires.Before(r.cache[oldest].expires) {
+				oldest = k
+			}
+		}
+		delete(r.cache, oldest)
+	}
+	r.cache[key] = cachedDigest{digest: d, expires: now.Add(r.ttl)}
+}
+
+func (r *digestResolver) fetch(ctx context.Context, image reference.NamedTagged, auth registryAuth) (digest.Digest, error) {
+	host := reference.Domain(image)
+	if host == dockerHubDomain {
+		host = dockerHubRegistry
+	}
+	manifest := fmt.Sprintf("https://%s/v2/%s/manifests/%s", host, reference.Path(image), image.Tag())
+
+	resp, err := r.get(ctx, http.MethodHead, manifest, "")
+	if err != nil {
+		return "", err
+	}
+	authorization := ""
+	if resp.StatusCode == http.StatusUnauthorized {
+		if authorization, err = r.authorize(ctx, resp.Header.Get("WWW-Authenticate"), auth); err != nil {
+			return "", errors.Wrapf(err, "failed to authenticate to %s", host)
+		}
+		if resp, err = r.get(ctx, http.MethodHead, manifest, authorization); err != nil {
+			return "", err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("failed to get manifest of %s: %s", image, resp.Status)
+	}
+	if d := resp.Header.Get("Docker-Content-Digest"); d != "" {
+		return digest.Parse(d)
+	}
+	// not all registries return the digest for HEAD requests, compute it from the manifest instead
+	return r.digestFromManifest(ctx, manifest, authorization)
+}
+
+func (r *digestResolver) digestFromManifest(ctx context.Context, manifest, authorization string) (digest.Digest, error) {
+	resp, err := r.get(ctx, http.MethodGet, manifest, authorization)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("failed to get manifest %s: %s", manifest, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read manifest %s", manifest)
+	}
+	return digest.FromBytes(body), nil
+}
+
+func (r *digestResolver) get(ctx context.Context, method, url, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to request %s", url)
+	}
+	if method == http.MethodHead {
+		resp.Body.Close()
+	}
+	return resp, nil
+}
+
+// authorize returns the Authorization header answering a Basic or Bearer WWW-Authenticate challenge
+func (r *digestResolver) authorize(ctx context.Context, challenge string, auth registryAuth) (string, error) {
+	username, password := auth.credentials()
+	if strings.HasPrefix(challenge, "Basic") {
+		if username == "" {
+			return "", errors.New("no credentials found in the image pull secrets")
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)), nil
+	}
+	if !strings.HasPrefix(challenge, "Bearer") {
+		return "", errors.Errorf("unsupported authentication challenge %q", challenge)
+	}
+
+	params := map[string]string{}
+	for _, match := range challengeParams.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", errors.Errorf("invalid realm in authentication challenge %q", challenge)
+	}
+	query := realm.Query()
+	for _, param := range []string{"service", "scope"} {
+		if params[param] != "" {
+			query.Set(param, params[param])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to request token from %s", realm.Host)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("failed to request token from %s: %s", realm.Host, resp.Status)
+	}
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", errors.Wrapf(err, "failed to decode token from %s", realm.Host)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	return "Bearer " + token.Token, nil
+}
+
+func (auth registryAuth) credentials() (string, string) {
+	if auth.Username != "" || auth.Auth == "" {
+		return auth.Username, auth.Password
+	}
+	decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+	if err != nil {
+		return "", ""
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
+
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
+
+// PinDigests rewrites the images of the pod to the digest their tag currently points to, using the image pull secrets
+// of the pod to authenticate. Images that cannot be resolved within digestTimeout are left as is unless
+// ImageDigestFailClosed is set.
+func (handler *podHandler) PinDigests(ctx context.Context, namespace string, pod *corev1.Pod) error {
+	ctx, cancel := context.WithTimeout(ctx, digestTimeout)
+	defer cancel()
+	secrets := []corev1.Secret{}
+	for _, ref := range pod.Spec.ImagePullSecrets {
+		secret := corev1.Secret{}
+		// secrets are read from the API server, so that the webhook does not cache every secret of the cluster
+		if err := handler.reader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, &secret); apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to get image pull secret %s", ref.Name)
+		}
+		secrets = append(secrets, secret)
+	}
+	auths := registryAuths(secrets...)
+
+	pin := func(containers []corev1.Container) error {
+		for i, container := range containers {
+			pinned, err := handler.digests.Pin(ctx, container.Image, auths)
+			if err != nil && handler.ImageDigestFailClosed {
+				return errors.Wrapf(err, "failed to pin image %s of container %s to a digest", container.Image, container.Name)
+			} else if err != nil {
+				handler.Log.Error(err, "Not pinning image", "container", container.Name, "image", container.Image)
+				continue
+			}
+			if pinned != container.Image {
+				handler.Log.Info("Pinning image", "from", container.Image, "to", pinned)
+				containers[i].Image = pinned
+			}
+		}
+		return nil
+	}
+	if err := pin(pod.Spec.InitContainers); err != nil {
+		return err
+	}
+	return pin(pod.Spec.Containers)
+}
+
+// registryAuths returns the credentials per registry domain of docker config pull secrets
+func registryAuths(secrets ...corev1.Secret) map[string]registryAuth {
+	auths := map[string]registryAuth{}
+	for _, secret := range secrets {
+		config := map[string]registryAuth{}
+		switch secret.Type {
+		case corev1.SecretTypeDockerConfigJson:
+			dockerConfig := struct {
+				Auths map[string]registryAuth `json:"auths"`
+			}{}
+			if err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &dockerConfig); err != nil {
+				log.Error(err, "Ignoring invalid pull secret", "namespace", secret.Namespace, "name", secret.Name)
+				continue
+			}
+			config = dockerConfig.Auths
+		case corev1.SecretTypeDockercfg:
+			if err := json.Unmarshal(secret.Data[corev1.DockerConfigKey], &config); err != nil {
+				log.Error(err, "Ignoring invalid pull secret", "namespace", secret.Namespace, "name", secret.Name)
+				continue
+			}
+		}
+		for registry, auth := range config {
+			domain := registryDomain(registry)
+			if _, exists := auths[domain]; !exists {
+				auths[domain] = auth
+			}
+		}
+	}
+	return auths
+}
+
+// registryDomain returns the domain of a docker config registry key, e.g. https://index.docker.io/v1/ -> docker.io
+func registryDomain(registry string) string {
+	registry = strings.TrimPrefix(strings.TrimPrefix(registry, "https://"), "http://")
+	registry = strings.Split(registry, "/")[0]
+	switch registry {
+	case "index.docker.io", dockerHubRegistry:
+		return dockerHubDomain
+	}
+	return registry
+}
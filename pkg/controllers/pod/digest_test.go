@@ -0,0 +1,165 @@
+package pod
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const appDigest = "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+// newRegistry returns a registry stand-in serving app:1.0, that requires a bearer token issued for user:password
+func newRegistry(requests *int) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "password" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Query().Get("scope") != "repository:team/app:pull" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"token": "secret-token"}`)
+		case r.Header.Get("Authorization") != "Bearer secret-token":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:team/app:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/v2/team/app/manifests/1.0":
+			*requests++
+			w.Header().Set("Docker-Content-Digest", appDigest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server
+}
+
+func newPullSecret(registry string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "pull-secret", Namespace: "default"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(fmt.Sprintf(`{"auths": {"https://%s": {"auth": "dXNlcjpwYXNzd29yZA=="}}}`, registry)),
+		},
+	}
+}
+
+func TestPinDigests(t *testing.T) {
+	requests := 0
+	server := newRegistry(&requests)
+	defer server.Close()
+	registry := strings.TrimPrefix(server.URL, "https://")
+
+	handler := &podHandler{
+		reader:           fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(newPullSecret(registry)).Build(),
+		Log:              logf.Log,
+		PodMutaterConfig: platformv1.PodMutaterConfig{ImageDigestFailClosed: true},
+		digests:          newDigestResolver(server.Client(), time.Minute),
+	}
+	newPod := func() *corev1.Pod {
+		return &corev1.Pod{Spec: corev1.PodSpec{
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: "pull-secret"}},
+			Containers: []corev1.Container{
+				{Name: "app", Image: registry + "/team/app:1.0"},
+				{Name: "pinned", Image: registry + "/team/app@" + appDigest},
+			},
+		}}
+	}
+
+	for i := 0; i < 2; i++ {
+		pod := newPod()
+		if err := handler.PinDigests(context.Background(), "default", pod); err != nil {
+			t.Fatal(err)
+		}
+		for _, container := range pod.Spec.Containers {
+			if container.Image != registry+"/team/app@"+appDigest {
+				t.Errorf("expected %s to be pinned, got %s", container.Name, container.Image)
+			}
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected the digest to be cached, got %d requests", requests)
+	}
+
+	pod := newPod()
+	pod.Spec.Containers[0].Image = registry + "/team/app:2.0"
+	if err := handler.PinDigests(context.Background(), "default", pod); err == nil || !strings.Contains(err.Error(), "container app") {
+		t.Errorf("expected an unknown tag to fail closed, got %v", err)
+	}
+
+	handler.ImageDigestFailClosed = false
+	if err := handler.PinDigests(context.Background(), "default", pod); err != nil || pod.Spec.Containers[0].Image != registry+"/team/app:2.0" {
+		t.Errorf("expected an unknown tag to be left as is when failing open, got %v", err)
+	}
+
+	pod = newPod()
+	pod.Spec.ImagePullSecrets = nil
+	handler.ImageDigestFailClosed = true
+	handler.digests = newDigestResolver(server.Client(), time.Minute)
+	if err := handler.PinDigests(context.Background(), "default", pod); err == nil {
+		t.Error("expected pinning without credentials to fail")
+	}
+}
+
+func TestPinDigestsCancelled(t *testing.T) {
+	requests := 0
+	server := newRegistry(&requests)
+	defer server.Close()
+	registry := strings.TrimPrefix(server.URL, "https://")
+	handler := &podHandler{
+		reader:           fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(newPullSecret(registry)).Build(),
+		Log:              logf.Log,
+		PodMutaterConfig: platformv1.PodMutaterConfig{ImageDigestFailClosed: true},
+		digests:          newDigestResolver(server.Client(), time.Minute),
+	}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "pull-secret"}},
+		Containers:       []corev1.Container{{Name: "app", Image: registry + "/team/app:1.0"}},
+	}}
+
+	// the admission request is no longer waiting for an answer
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := handler.PinDigests(ctx, "default", pod); err == nil || requests != 0 {
+		t.Errorf("expected resolving to stop with the request, got %v after %d requests", err, requests)
+	}
+}
+
+func TestDigestCacheEviction(t *testing.T) {
+	resolver := newDigestResolver(nil, time.Minute)
+	resolver.size = 2
+	now := time.Now()
+	resolver.store("expired", appDigest, now.Add(-2*time.Minute))
+	resolver.store("first", appDigest, now.Add(-time.Second))
+	resolver.store("second", appDigest, now)
+	if _, ok := resolver.cache["expired"]; ok || len(resolver.cache) != 2 {
+		t.Errorf("expected the expired digest to be evicted, got %v", resolver.cache)
+	}
+	resolver.store("third", appDigest, now)
+	if _, ok := resolver.cache["first"]; ok || len(resolver.cache) != 2 {
+		t.Errorf("expected the digest expiring first to be evicted, got %v", resolver.cache)
+	}
+}
+
+func TestRegistryAuths(t *testing.T) {
+	auths := registryAuths(*newPullSecret("index.docker.io/v1/"), *newPullSecret("registry.corp:5000"))
+	if username, password := auths["docker.io"].credentials(); username != "user" || password != "password" {
+		t.Errorf("expected docker hub credentials, got %v", auths)
+	}
+	if _, ok := auths["registry.corp:5000"]; !ok {
+		t.Errorf("expected registry.corp:5000 credentials, got %v", auths)
+	}
+}
@@ -0,0 +1,137 @@
+package pod
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// EnvAnnotation on a namespace is a ; separated list of NAME=value environment variables injected into the
+	// containers of its pods, overriding the variables of the same name from the flags
+	EnvAnnotation = "platform.flanksource.com/env"
+
+	// CABundleMountPath is the directory the CA bundle is mounted in
+	CABundleMountPath = "/etc/platform/ca-bundle"
+	caBundleVolume    = "platform-ca-bundle"
+)
+
+// caBundleEnv are the variables pointing common runtimes to the CA bundle: OpenSSL and Go, Node.js and Python requests
+var caBundleEnv = []string{"SSL_CERT_FILE", "NODE_EXTRA_CA_CERTS", "REQUESTS_CA_BUNDLE"}
+
+// UpdateEnv injects the configured environment variables into all containers, and mounts the CA bundle ConfigMap when
+// it exists in the namespace. Variables and mount paths the containers already define are not overwritten.
+func (handler *podHandler) UpdateEnv(ctx context.Context, namespace string, pod *corev1.Pod) *corev1.Pod {
+	env := []corev1.EnvVar{}
+	names := []string{}
+	for name := range handler.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, corev1.EnvVar{Name: name, Value: handler.Env[name]})
+	}
+
+	var mount *corev1.VolumeMount
+	if handler.CABundleConfigMap != "" && handler.configMapExists(ctx, namespace, handler.CABundleConfigMap) {
+		if !hasVolume(pod, caBundleVolume) {
+			pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+				Name: caBundleVolume,
+				VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: handler.CABundleConfigMap},
+				}},
+			})
+		}
+		mount = &corev1.VolumeMount{Name: caBundleVolume, MountPath: CABundleMountPath, ReadOnly: true}
+		file := path.Join(CABundleMountPath, handler.CABundleKey)
+		for _, name := range caBundleEnv {
+			env = append(env, corev1.EnvVar{Name: name, Value: file})
+		}
+	}
+	if len(env) == 0 {
+		return pod
+	}
+
+	update := func(containers []corev1.Container) {
+		for i := range containers {
+			container := &containers[i]
+			for _, envVar := range env {
+				if !hasEnv(container, envVar.Name) {
+					container.Env = append(container.Env, envVar)
+				}
+			}
+			if mount != nil && !hasMountPath(container, mount.MountPath) {
+				container.VolumeMounts = append(container.VolumeMounts, *mount)
+			}
+		}
+	}
+	update(pod.Spec.InitContainers)
+	update(pod.Spec.Containers)
+	return pod
+}
+
+func (handler *podHandler) configMapExists(ctx context.Context, namespace, name string) bool {
+	cm := corev1.ConfigMap{}
+	if err := handler.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &cm); err != nil {
+		handler.Log.Info("Not mounting CA bundle", "namespace", namespace, "name", name, "reason", err.Error())
+		return false
+	}
+	return true
+}
+
+// ParseEnv parses a ; separated list of NAME=value environment variables, ; is used as values such as NO_PROXY are
+// comma separated
+func ParseEnv(value string) (map[string]string, error) {
+	env := map[string]string{}
+	for _, pair := range strings.Split(value, ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid environment variable %s, expected NAME=value", pair)
+		}
+		if errs := validation.IsEnvVarName(name); len(errs) > 0 {
+			return nil, errors.Errorf("invalid environment variable name %s: %s", name, strings.Join(errs, ", "))
+		}
+		env[name] = parts[1]
+	}
+	return env, nil
+}
+
+// mergeEnv returns the variables of env overridden by those of overrides
+func mergeEnv(env, overrides map[string]string) map[string]string {
+	merged := map[string]string{}
+	for name, value := range env {
+		merged[name] = value
+	}
+	for name, value := range overrides {
+		merged[name] = value
+	}
+	return merged
+}
+
+func hasEnv(container *corev1.Container, name string) bool {
+	for _, env := range container.Env {
+		if env.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasMountPath(container *corev1.Container, mountPath string) bool {
+	for _, mount := range container.VolumeMounts {
+		if path.Clean(mount.MountPath) == mountPath {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package pod
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestUpdateEnv(t *testing.T) {
+	env, err := ParseEnv("HTTP_PROXY=http://proxy:3128; NO_PROXY=.cluster.local,10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "ca-bundle", Namespace: "team-a"}}
+	handler := &podHandler{
+		Client:           fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(bundle).Build(),
+		Log:              logf.Log,
+		PodMutaterConfig: platformv1.PodMutaterConfig{Env: env, CABundleConfigMap: "ca-bundle", CABundleKey: "ca.crt"},
+	}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "init"}},
+		Containers: []corev1.Container{{
+			Name:         "app",
+			Env:          []corev1.EnvVar{{Name: "HTTP_PROXY", Value: "http://other:8080"}},
+			VolumeMounts: []corev1.VolumeMount{{Name: "own-ca", MountPath: CABundleMountPath + "/"}},
+		}},
+	}}
+
+	pod = handler.UpdateEnv(context.Background(), "team-a", pod)
+	expected := []corev1.EnvVar{
+		{Name: "HTTP_PROXY", Value: "http://proxy:3128"},
+		{Name: "NO_PROXY", Value: ".cluster.local,10.0.0.0/8"},
+		{Name: "SSL_CERT_FILE", Value: "/etc/platform/ca-bundle/ca.crt"},
+		{Name: "NODE_EXTRA_CA_CERTS", Value: "/etc/platform/ca-bundle/ca.crt"},
+		{Name: "REQUESTS_CA_BUNDLE", Value: "/etc/platform/ca-bundle/ca.crt"},
+	}
+	if init := pod.Spec.InitContainers[0]; !reflect.DeepEqual(init.Env, expected) || len(init.VolumeMounts) != 1 {
+		t.Errorf("expected the env and CA bundle to be injected, got %v %v", init.Env, init.VolumeMounts)
+	}
+	if app := pod.Spec.Containers[0]; app.Env[0].Value != "http://other:8080" || len(app.Env) != 5 || len(app.VolumeMounts) != 1 {
+		t.Errorf("expected existing variables and mounts to be kept, got %v %v", app.Env, app.VolumeMounts)
+	}
+	if len(pod.Spec.Volumes) != 1 || pod.Spec.Volumes[0].ConfigMap.Name != "ca-bundle" {
+		t.Errorf("expected the CA bundle volume, got %v", pod.Spec.Volumes)
+	}
+
+	other := handler.UpdateEnv(context.Background(), "team-b", &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}})
+	if len(other.Spec.Volumes) != 0 || len(other.Spec.Containers[0].Env) != 2 {
+		t.Errorf("expected the CA bundle not to be mounted before it is replicated, got %v", other.Spec)
+	}
+}
+
+func TestParseEnv(t *testing.T) {
+	for _, value := range []string{"HTTP_PROXY", "1INVALID=true"} {
+		if _, err := ParseEnv(value); err == nil {
+			t.Errorf("expected %s to be invalid", value)
+		}
+	}
+
+	namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{EnvAnnotation: "CLUSTER=team-a"}}}
+	cfg := platformv1.PodMutaterConfig{Env: map[string]string{"CLUSTER": "prod", "HTTP_PROXY": "http://proxy:3128"}}
+	resolved := applyNamespaceOverrides(cfg, namespace)
+	if !reflect.DeepEqual(resolved.Env, map[string]string{"CLUSTER": "team-a", "HTTP_PROXY": "http://proxy:3128"}) || cfg.Env["CLUSTER"] != "prod" {
+		t.Errorf("expected the namespace to override variables, got %v", resolved.Env)
+	}
+}
@@ -0,0 +1,230 @@
+package pod
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// hardeningHandler denies pods breaking out of their isolation, i.e. privileged containers, host namespaces, hostPath
+// volumes and added capabilities, unless a PodHardeningExemption exempts them
+type hardeningHandler struct {
+	Client client.Client
+	*admission.Decoder
+	Log logr.Logger
+	platformv1.PodHardeningConfig
+}
+
+// +kubebuilder:rbac:groups=platform.flanksource.com,resources=podhardeningexemptions,verbs=get;list;watch
+
+//+kubebuilder:webhook:path=/validate-v1-pod-hardening,mutating=false,sideEffects=None,admissionReviewVersions=v1,failurePolicy=fail,groups="",resources=pods;pods/ephemeralcontainers,verbs=create;update,versions=v1,name=validate-pod-hardening.platform.flanksource.com
+func NewHardeningValidatingWebhook(client client.Client, cfg platformv1.PodHardeningConfig) *admission.Webhook {
+	decoder, _ := admission.NewDecoder(client.Scheme())
+	return &admission.Webhook{
+		Handler: &hardeningHandler{
+			Client:             client,
+			Decoder:            decoder,
+			PodHardeningConfig: cfg,
+			Log:                logf.Log.WithName("pod-hardening")},
+	}
+}
+
+var _ admission.Handler = &hardeningHandler{}
+
+func (handler *hardeningHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if !handler.Enabled || (req.SubResource != "" && req.SubResource != ephemeralContainersSubResource) || contains(handler.ExemptNamespaces, req.Namespace) {
+		return admission.Allowed("")
+	}
+	pod, old := &corev1.Pod{}, &corev1.Pod{}
+	if req.Kind.Kind == "EphemeralContainers" {
+		// Kubernetes versions before 1.22 only send the ephemeral containers, which are validated on their own
+		containers, oldContainers := &corev1.EphemeralContainers{}, &corev1.EphemeralContainers{}
+		if err := handler.Decode(req, containers); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		if err := handler.DecodeRaw(req.OldObject, oldContainers); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		pod.Name = req.Name
+		pod.Spec.EphemeralContainers = containers.EphemeralContainers
+		old.Spec.EphemeralContainers = oldContainers.EphemeralContainers
+	} else if err := handler.Decode(req, pod); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	} else if req.Operation == admissionv1.Update {
+		if err := handler.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+	if req.Operation == admissionv1.Update && reflect.DeepEqual(images(&old.Spec), images(&pod.Spec)) {
+		// the fields validated cannot be changed after creation, but changing an image can lose its exemption
+		return admission.Allowed("")
+	}
+
+	// exemptions are only looked up for pods violating a rule, which is the exception
+	if len(validateHardening(pod, nil)) == 0 {
+		return admission.Allowed("")
+	}
+	namespace, err := getNamespace(ctx, handler.Client, req.Namespace)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	exemptions := platformv1.PodHardeningExemptionList{}
+	if err := handler.Client.List(ctx, &exemptions); err != nil {
+		return admission.Errored(http.StatusInternalServerError, errors.Wrap(err, "failed to list pod hardening exemptions"))
+	}
+	selected := []platformv1.PodHardeningExemption{}
+	for _, exemption := range exemptions.Items {
+		if selectorMatches(exemption.Name, exemption.Spec.NamespaceSelector, namespace.Labels) {
+			selected = append(selected, exemption)
+		}
+	}
+
+	violations := validateHardening(pod, selected)
+	if len(violations) == 0 {
+		return admission.Allowed("")
+	}
+	if handler.WarnOnly {
+		handler.Log.Info("Pod hardening violation", "namespace", req.Namespace, "name", podName(req, pod), "violations", violations)
+		return admission.Allowed("").WithWarnings(violations...)
+	}
+	return admission.Denied(fmt.Sprintf("pod %s/%s violates the pod hardening policy: %s", req.Namespace, podName(req, pod), strings.Join(violations, "; ")))
+}
+
+// validateHardening returns a message for every hardening rule broken by the pod that the exemptions do not exempt it
+// from. Pod level rules are only exempted when all containers are, and hostPath volumes when all containers mounting
+// them are.
+func validateHardening(pod *corev1.Pod, exemptions []platformv1.PodHardeningExemption) []string {
+	containers := hardeningContainers(pod)
+	exempt := func(rule platformv1.PodHardeningRule, containers []corev1.Container) bool {
+		for _, container := range containers {
+			if !isExemptImage(exemptions, rule, container.Image) {
+				return false
+			}
+		}
+		return len(exemptions) > 0
+	}
+
+	violations := []string{}
+	for _, host := range []struct {
+		rule    platformv1.PodHardeningRule
+		enabled bool
+		message string
+	}{
+		{platformv1.HostNetworkRule, pod.Spec.HostNetwork, "the host network is not allowed"},
+		{platformv1.HostPIDRule, pod.Spec.HostPID, "the host PID namespace is not allowed"},
+		{platformv1.HostIPCRule, pod.Spec.HostIPC, "the host IPC namespace is not allowed"},
+	} {
+		if host.enabled && !exempt(host.rule, containers) {
+			violations = append(violations, fmt.Sprintf("violates %s: %s", host.rule, host.message))
+		}
+	}
+
+	for _, volume := range pod.Spec.Volumes {
+		if volume.HostPath == nil {
+			continue
+		}
+		mounting := []corev1.Container{}
+		for _, container := range containers {
+			if mountsVolume(container, volume.Name) {
+				mounting = append(mounting, container)
+			}
+		}
+		if len(mounting) == 0 {
+			mounting = containers
+		}
+		if !exempt(platformv1.HostPathRule, mounting) {
+			violations = append(violations, fmt.Sprintf("volume %s violates %s: hostPath volumes are not allowed", volume.Name, platformv1.HostPathRule))
+		}
+	}
+
+	for _, container := range containers {
+		sc := container.SecurityContext
+		if sc == nil {
+			continue
+		}
+		if sc.Privileged != nil && *sc.Privileged && !isExemptImage(exemptions, platformv1.PrivilegedRule, container.Image) {
+			violations = append(violations, fmt.Sprintf("container %s violates %s: privileged containers are not allowed", container.Name, platformv1.PrivilegedRule))
+		}
+		if sc.Capabilities != nil && len(sc.Capabilities.Add) > 0 && !isExemptImage(exemptions, platformv1.CapabilitiesRule, container.Image) {
+			added := []string{}
+			for _, capability := range sc.Capabilities.Add {
+				added = append(added, string(capability))
+			}
+			violations = append(violations, fmt.Sprintf("container %s violates %s: adding %s is not allowed", container.Name, platformv1.CapabilitiesRule, strings.Join(added, ",")))
+		}
+	}
+	return violations
+}
+
+// hardeningContainers returns the init, regular and ephemeral containers of the pod with the fields validated
+func hardeningContainers(pod *corev1.Pod) []corev1.Container {
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range pod.Spec.EphemeralContainers {
+		containers = append(containers, corev1.Container{
+			Name:            container.Name,
+			Image:           container.Image,
+			SecurityContext: container.SecurityContext,
+			VolumeMounts:    container.VolumeMounts,
+			VolumeDevices:   container.VolumeDevices,
+		})
+	}
+	return containers
+}
+
+func mountsVolume(container corev1.Container, volume string) bool {
+	for _, mount := range container.VolumeMounts {
+		if mount.Name == volume {
+			return true
+		}
+	}
+	for _, device := range container.VolumeDevices {
+		if device.Name == volume {
+			return true
+		}
+	}
+	return false
+}
+
+// isExemptImage returns true if an exemption exempts the image from the rule, images are matched by their normalized
+// repository so that e.g. cilium/cilium:v1.9 matches docker.io/cilium
+func isExemptImage(exemptions []platformv1.PodHardeningExemption, rule platformv1.PodHardeningRule, image string) bool {
+	name := image
+	if named, err := reference.ParseNormalizedNamed(image); err == nil {
+		name = named.Name()
+	}
+	for _, exemption := range exemptions {
+		if len(exemption.Spec.Rules) > 0 && !hasRule(exemption.Spec.Rules, rule) {
+			continue
+		}
+		if len(exemption.Spec.Images) == 0 {
+			return true
+		}
+		for _, prefix := range exemption.Spec.Images {
+			if prefix != "" && hasPathPrefix(name, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasRule(rules []platformv1.PodHardeningRule, rule platformv1.PodHardeningRule) bool {
+	for _, r := range rules {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package pod
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestValidateHardening(t *testing.T) {
+	yes := true
+	exporter := func(sc *corev1.SecurityContext, mounts ...string) corev1.Container {
+		container := corev1.Container{Name: "exporter", Image: "quay.io/prometheus/node-exporter:v1.1.2", SecurityContext: sc}
+		for _, mount := range mounts {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: mount, MountPath: "/" + mount})
+		}
+		return container
+	}
+	app := corev1.Container{Name: "app", Image: "nginx:1.19"}
+	hostPath := corev1.Volume{Name: "proc", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/proc"}}}
+	exemption := platformv1.PodHardeningExemption{Spec: platformv1.PodHardeningExemptionSpec{
+		Images: []string{"quay.io/prometheus/node-exporter"},
+		Rules:  []platformv1.PodHardeningRule{platformv1.HostPIDRule, platformv1.HostPathRule, platformv1.PrivilegedRule},
+	}}
+
+	fixtures := []struct {
+		name       string
+		spec       corev1.PodSpec
+		exemptions []platformv1.PodHardeningExemption
+		violations []string
+	}{
+		{"hardened", corev1.PodSpec{Containers: []corev1.Container{app}}, nil, nil},
+		{"every violation", corev1.PodSpec{
+			HostNetwork: true,
+			HostPID:     true,
+			HostIPC:     true,
+			Volumes:     []corev1.Volume{hostPath},
+			Containers: []corev1.Container{exporter(&corev1.SecurityContext{
+				Privileged:   &yes,
+				Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"NET_ADMIN", "SYS_TIME"}},
+			}, "proc")},
+			EphemeralContainers: []corev1.EphemeralContainer{{EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+				Name: "debug", Image: "busybox", SecurityContext: &corev1.SecurityContext{Privileged: &yes},
+			}}},
+		}, nil, []string{
+			"violates hostNetwork",
+			"violates hostPID",
+			"violates hostIPC",
+			"volume proc violates hostPath",
+			"container exporter violates privileged",
+			"container exporter violates capabilities: adding NET_ADMIN,SYS_TIME",
+			"container debug violates privileged",
+		}},
+		{"exempt image", corev1.PodSpec{
+			HostPID:    true,
+			Volumes:    []corev1.Volume{hostPath},
+			Containers: []corev1.Container{exporter(&corev1.SecurityContext{Privileged: &yes}, "proc"), app},
+		}, []platformv1.PodHardeningExemption{exemption}, []string{"violates hostPID"}},
+		{"exempt pod", corev1.PodSpec{
+			HostPID:    true,
+			Containers: []corev1.Container{exporter(nil)},
+		}, []platformv1.PodHardeningExemption{exemption}, nil},
+		{"rule not exempted", corev1.PodSpec{
+			HostNetwork: true,
+			Containers:  []corev1.Container{exporter(nil)},
+		}, []platformv1.PodHardeningExemption{exemption}, []string{"violates hostNetwork"}},
+		{"hostPath mounted by another container", corev1.PodSpec{
+			Volumes:    []corev1.Volume{hostPath},
+			Containers: []corev1.Container{exporter(nil), {Name: "app", Image: "nginx:1.19", VolumeMounts: []corev1.VolumeMount{{Name: "proc"}}}},
+		}, []platformv1.PodHardeningExemption{exemption}, []string{"volume proc violates hostPath"}},
+		{"exempt namespace", corev1.PodSpec{
+			HostNetwork: true,
+			Containers:  []corev1.Container{app},
+		}, []platformv1.PodHardeningExemption{{}}, nil},
+	}
+	for _, fixture := range fixtures {
+		t.Run(fixture.name, func(t *testing.T) {
+			violations := validateHardening(&corev1.Pod{Spec: fixture.spec}, fixture.exemptions)
+			if len(violations) != len(fixture.violations) {
+				t.Fatalf("expected %v, got %v", fixture.violations, violations)
+			}
+			for i, violation := range fixture.violations {
+				if !strings.Contains(violations[i], violation) {
+					t.Errorf("expected %s, got %s", violation, violations[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHardeningHandle(t *testing.T) {
+	monitoring := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "monitoring", Labels: map[string]string{"team": "monitoring"}}}
+	tenant := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}}
+	exemption := &platformv1.PodHardeningExemption{
+		ObjectMeta: metav1.ObjectMeta{Name: "monitoring"},
+		Spec: platformv1.PodHardeningExemptionSpec{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "monitoring"}},
+		},
+	}
+	c := newSidecarHandler(t, monitoring, tenant, exemption).Client
+	decoder, _ := admission.NewDecoder(c.Scheme())
+	handler := &hardeningHandler{Client: c, Decoder: decoder, Log: logf.Log, PodHardeningConfig: platformv1.PodHardeningConfig{Enabled: true}}
+	request := func(namespace string) admission.Request {
+		raw, _ := json.Marshal(&corev1.Pod{Spec: corev1.PodSpec{
+			HostNetwork: true,
+			Containers:  []corev1.Container{{Name: "agent", Image: "agent:v1"}},
+		}})
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Name:      "agent",
+			Namespace: namespace,
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Object:    runtime.RawExtension{Raw: raw},
+		}}
+	}
+	ctx := context.Background()
+
+	if response := handler.Handle(ctx, request("monitoring")); !response.Allowed {
+		t.Errorf("expected the pod to be exempt in the monitoring namespace, got %v", response.Result)
+	}
+	response := handler.Handle(ctx, request("tenant"))
+	if response.Allowed || !strings.Contains(string(response.Result.Reason), "pod tenant/agent violates the pod hardening policy: violates hostNetwork") {
+		t.Errorf("expected the pod to be denied in the tenant namespace, got %v", response.Result)
+	}
+
+	handler.WarnOnly = true
+	if response := handler.Handle(ctx, request("tenant")); !response.Allowed || len(response.Warnings) != 1 {
+		t.Errorf("expected the pod to be allowed with a warning, got %v %v", response.Result, response.Warnings)
+	}
+	handler.WarnOnly = false
+	if response := handler.Handle(ctx, request("missing")); response.Allowed || response.Result.Code != http.StatusInternalServerError {
+		t.Errorf("expected a failed namespace lookup to be a server error, got %v", response.Result)
+	}
+	handler.Enabled = false
+	if response := handler.Handle(ctx, request("tenant")); !response.Allowed || len(response.Warnings) != 0 {
+		t.Errorf("expected all pods to be allowed when pod hardening is disabled, got %v", response.Result)
+	}
+	handler.Enabled = true
+	handler.ExemptNamespaces = []string{"tenant"}
+	if response := handler.Handle(ctx, request("tenant")); len(response.Warnings) != 0 {
+		t.Errorf("expected pods in exempt namespaces not to be validated, got %v", response.Warnings)
+	}
+}
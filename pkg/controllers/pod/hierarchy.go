@@ -0,0 +1,248 @@
+package pod
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// NamespaceHierarchy resolves the ancestors of namespaces modelled with a parent label, HNC style. Ancestor chains are
+// cached until any namespace changes, as observed by InvalidateOnChange on every replica and by the NamespaceReconciler
+// on the leader, so the webhook and reconcilers of the same manager must share a NamespaceHierarchy.
+type NamespaceHierarchy struct {
+	client      client.Client
+	parentLabel string
+	// inheritable returns true for the annotations inherited from ancestors
+	inheritable func(key string) bool
+
+	mu     sync.Mutex
+	chains map[string]ancestorChain
+	// generation is incremented by Invalidate so that chains resolved concurrently are not cached
+	generation int
+}
+
+type ancestorChain struct {
+	ancestors []corev1.Namespace
+	err       error
+}
+
+// NewNamespaceHierarchy returns a hierarchy following parentLabel, no namespace has ancestors when it is empty. Only the
+// annotations whitelisted by cfg and the namespace settings of the pod mutator are inherited from ancestors.
+func NewNamespaceHierarchy(c client.Client, parentLabel string, cfg platformv1.PodMutaterConfig) *NamespaceHierarchy {
+	return &NamespaceHierarchy{
+		client:      c,
+		parentLabel: parentLabel,
+		inheritable: inheritableAnnotations(cfg),
+		chains:      map[string]ancestorChain{},
+	}
+}
+
+// inheritableAnnotations returns true for the whitelisted annotations, the tolerations and node selector annotations
+// and the namespace overrides of cfg
+func inheritableAnnotations(cfg platformv1.PodMutaterConfig) func(key string) bool {
+	settings := map[string]bool{
+		RegistryPrefixAnnotation:       true,
+		ImagePullSecretsAnnotation:     true,
+		DefaultRequestsAnnotation:      true,
+		DefaultLimitsAnnotation:        true,
+		MaxLimitRequestRatioAnnotation: true,
+		EnvAnnotation:                  true,
+		InjectSidecarsAnnotation:       true,
+	}
+	for _, key := range []string{cfg.TolerationsAnnotation, cfg.NodeSelectorAnnotation} {
+		if key != "" {
+			settings[key] = true
+		}
+	}
+	return func(key string) bool {
+		return settings[key] || isWhitelisted(key, cfg)
+	}
+}
+
+// InvalidateOnChange invalidates the cached ancestor chains on any change to a namespace seen by the informer. Unlike
+// the NamespaceReconciler it runs on every replica, including those serving the webhook without being the leader.
+func (h *NamespaceHierarchy) InvalidateOnChange(ctx context.Context, informers cache.Informers) error {
+	if h == nil || h.parentLabel == "" {
+		return nil
+	}
+	informer, err := informers.GetInformer(ctx, &corev1.Namespace{})
+	if err != nil {
+		return errors.Wrap(err, "failed to get namespace informer")
+	}
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { h.Invalidate() },
+		UpdateFunc: func(interface{}, interface{}) { h.Invalidate() },
+		DeleteFunc: func(interface{}) { h.Invalidate() },
+	})
+	return nil
+}
+
+// Ancestors returns the ancestors of the namespace from its parent to the root. The chain ends at the first parent that
+// does not exist, while an error is returned without any ancestors for cycles.
+func (h *NamespaceHierarchy) Ancestors(ctx context.Context, ns corev1.Namespace) ([]corev1.Namespace, error) {
+	if h == nil || h.parentLabel == "" {
+		return nil, nil
+	}
+	h.mu.Lock()
+	chain, ok := h.chains[ns.Name]
+	generation := h.generation
+	h.mu.Unlock()
+	if ok {
+		return chain.ancestors, chain.err
+	}
+
+	ancestors, err := walkAncestors(ns, h.parentLabel, func(name string) (*corev1.Namespace, error) {
+		parent := &corev1.Namespace{}
+		if err := h.client.Get(ctx, types.NamespacedName{Name: name}, parent); apierrors.IsNotFound(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return parent, nil
+	})
+	if err != nil && !isCycle(err) {
+		// lookup errors are transient and not cached
+		return nil, err
+	}
+	h.mu.Lock()
+	if generation == h.generation {
+		h.chains[ns.Name] = ancestorChain{ancestors: ancestors, err: err}
+	}
+	h.mu.Unlock()
+	return ancestors, err
+}
+
+// Inherit returns the namespace with the annotations of its ancestors merged in, the nearest ancestor winning. Errors
+// resolving the ancestors are logged and recorded as events on the namespace, which is then returned as is.
+func (h *NamespaceHierarchy) Inherit(ctx context.Context, recorder record.EventRecorder, ns corev1.Namespace) corev1.Namespace {
+	ancestors, err := h.Ancestors(ctx, ns)
+	if err != nil {
+		log.Error(err, "Not inheriting from ancestors", "namespace", ns.Name)
+		if recorder != nil {
+			recorder.Eventf(&ns, corev1.EventTypeWarning, "InvalidNamespaceHierarchy", "Not inheriting from ancestors: %s", err)
+		}
+		return ns
+	}
+	return mergeAncestors(ns, ancestors, h.inheritable)
+}
+
+// Invalidate drops the cached ancestor chains
+func (h *NamespaceHierarchy) Invalidate() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.chains = map[string]ancestorChain{}
+	h.generation++
+	h.mu.Unlock()
+}
+
+// namespaceAndDescendants returns requests for the namespace and all namespaces below it, whose inherited annotations
+// change with it. The ancestor chains are invalidated as the namespace may have changed.
+func (h *NamespaceHierarchy) namespaceAndDescendants(obj client.Object) []reconcile.Request {
+	h.Invalidate()
+	requests := []reconcile.Request{{NamespacedName: types.NamespacedName{Name: obj.GetName()}}}
+	if h == nil || h.parentLabel == "" {
+		return requests
+	}
+	namespaces := corev1.NamespaceList{}
+	if err := h.client.List(context.Background(), &namespaces); err != nil {
+		log.Error(err, "Failed to list namespaces, not reconciling descendants", "namespace", obj.GetName())
+		return requests
+	}
+	for _, name := range descendants(obj.GetName(), h.parentLabel, namespaces.Items) {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: name}})
+	}
+	return requests
+}
+
+// walkAncestors follows the parent label from the namespace to the root, get returns nil for missing namespaces
+func walkAncestors(ns corev1.Namespace, parentLabel string, get func(name string) (*corev1.Namespace, error)) ([]corev1.Namespace, error) {
+	ancestors := []corev1.Namespace{}
+	path := []string{ns.Name}
+	visited := map[string]bool{ns.Name: true}
+	for parent := ns.Labels[parentLabel]; parent != ""; {
+		path = append(path, parent)
+		if visited[parent] {
+			return nil, cycleError{path: path}
+		}
+		visited[parent] = true
+		namespace, err := get(parent)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get namespace %s", parent)
+		}
+		if namespace == nil {
+			break
+		}
+		ancestors = append(ancestors, *namespace)
+		parent = namespace.Labels[parentLabel]
+	}
+	return ancestors, nil
+}
+
+// mergeAncestors returns a copy of the namespace with the inheritable annotations of the ancestors, ordered from the
+// parent to the root, merged in so that nearer namespaces win
+func mergeAncestors(ns corev1.Namespace, ancestors []corev1.Namespace, inheritable func(key string) bool) corev1.Namespace {
+	if len(ancestors) == 0 {
+		return ns
+	}
+	annotations := map[string]string{}
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		for k, v := range ancestors[i].Annotations {
+			if inheritable(k) {
+				annotations[k] = v
+			}
+		}
+	}
+	for k, v := range ns.Annotations {
+		annotations[k] = v
+	}
+	merged := *ns.DeepCopy()
+	merged.Annotations = annotations
+	return merged
+}
+
+// descendants returns the names of the namespaces below the named namespace
+func descendants(name, parentLabel string, namespaces []corev1.Namespace) []string {
+	children := map[string][]string{}
+	for _, ns := range namespaces {
+		if parent := ns.Labels[parentLabel]; parent != "" {
+			children[parent] = append(children[parent], ns.Name)
+		}
+	}
+	found := []string{}
+	visited := map[string]bool{name: true}
+	for queue := append([]string{}, children[name]...); len(queue) > 0; queue = queue[1:] {
+		if visited[queue[0]] {
+			continue
+		}
+		visited[queue[0]] = true
+		found = append(found, queue[0])
+		queue = append(queue, children[queue[0]]...)
+	}
+	return found
+}
+
+type cycleError struct {
+	path []string
+}
+
+func (e cycleError) Error() string {
+	return "cycle of parent namespaces " + strings.Join(e.path, " -> ")
+}
+
+func isCycle(err error) bool {
+	_, ok := err.(cycleError)
+	return ok
+}
@@ -0,0 +1,162 @@
+package pod
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var hierarchyConfig = platformv1.PodMutaterConfig{
+	AnnotationsMap:         annotationsMap([]string{"co.elastic"}),
+	TolerationsAnnotation:  "tolerations",
+	NodeSelectorAnnotation: "node-selector",
+}
+
+func newNamespace(name, parent string, annotations map[string]string) *corev1.Namespace {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+	if parent != "" {
+		ns.Labels = map[string]string{"parent": parent}
+	}
+	return ns
+}
+
+func TestInheritAncestors(t *testing.T) {
+	root := newNamespace("team", "", map[string]string{
+		"tolerations":             "group=team",
+		"node-selector":           "group=team",
+		"co.elastic.logs/enabled": "true",
+		"unrelated":               "team",
+	})
+	child := newNamespace("team-apps", "team", map[string]string{"tolerations": "group=apps"})
+	grandchild := newNamespace("team-apps-dev", "team-apps", map[string]string{"other": "dev"})
+	orphan := newNamespace("orphan", "missing", nil)
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(root, child, grandchild, orphan).Build()
+	hierarchy := NewNamespaceHierarchy(c, "parent", hierarchyConfig)
+
+	ancestors, err := hierarchy.Ancestors(context.Background(), *grandchild)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ancestors) != 2 || ancestors[0].Name != "team-apps" || ancestors[1].Name != "team" {
+		t.Fatalf("expected ancestors team-apps, team, got %v", ancestors)
+	}
+	inherited := hierarchy.Inherit(context.Background(), nil, *grandchild)
+	// only whitelisted annotations and namespace settings are inherited, the namespace keeps all its own annotations
+	expected := map[string]string{"tolerations": "group=apps", "node-selector": "group=team", "co.elastic.logs/enabled": "true", "other": "dev"}
+	if !reflect.DeepEqual(inherited.Annotations, expected) {
+		t.Errorf("expected %v, got %v", expected, inherited.Annotations)
+	}
+	if len(grandchild.Annotations) != 1 {
+		t.Errorf("expected the namespace not to be modified, got %v", grandchild.Annotations)
+	}
+
+	if ancestors, err := hierarchy.Ancestors(context.Background(), *orphan); err != nil || len(ancestors) != 0 {
+		t.Errorf("expected no ancestors for a missing parent, got %v, %v", ancestors, err)
+	}
+	if ancestors, _ := NewNamespaceHierarchy(c, "", hierarchyConfig).Ancestors(context.Background(), *grandchild); len(ancestors) != 0 {
+		t.Errorf("expected no ancestors without a parent label, got %v", ancestors)
+	}
+}
+
+func TestAncestorsCache(t *testing.T) {
+	root := newNamespace("team", "", map[string]string{"tolerations": "group=team"})
+	child := newNamespace("team-apps", "team", nil)
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(root, child).Build()
+	hierarchy := NewNamespaceHierarchy(c, "parent", hierarchyConfig)
+
+	if inherited := hierarchy.Inherit(context.Background(), nil, *child); inherited.Annotations["tolerations"] != "group=team" {
+		t.Fatalf("expected tolerations to be inherited, got %v", inherited.Annotations)
+	}
+	root.Annotations["tolerations"] = "group=other"
+	if err := c.Update(context.Background(), root); err != nil {
+		t.Fatal(err)
+	}
+	if inherited := hierarchy.Inherit(context.Background(), nil, *child); inherited.Annotations["tolerations"] != "group=team" {
+		t.Errorf("expected the cached ancestors to be used, got %v", inherited.Annotations)
+	}
+
+	requests := hierarchy.namespaceAndDescendants(root)
+	if len(requests) != 2 || requests[0].Name != "team" || requests[1].Name != "team-apps" {
+		t.Errorf("expected team and team-apps to be reconciled, got %v", requests)
+	}
+	if inherited := hierarchy.Inherit(context.Background(), nil, *child); inherited.Annotations["tolerations"] != "group=other" {
+		t.Errorf("expected the updated ancestor after invalidation, got %v", inherited.Annotations)
+	}
+}
+
+func TestInvalidateOnChange(t *testing.T) {
+	root := newNamespace("team", "", map[string]string{"tolerations": "group=team"})
+	child := newNamespace("team-apps", "team", nil)
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(root, child).Build()
+	hierarchy := NewNamespaceHierarchy(c, "parent", hierarchyConfig)
+	informers := &informertest.FakeInformers{}
+	if err := hierarchy.InvalidateOnChange(context.Background(), informers); err != nil {
+		t.Fatal(err)
+	}
+
+	hierarchy.Inherit(context.Background(), nil, *child)
+	updated := root.DeepCopy()
+	updated.Annotations["tolerations"] = "group=other"
+	if err := c.Update(context.Background(), updated); err != nil {
+		t.Fatal(err)
+	}
+	informer, err := informers.FakeInformerFor(&corev1.Namespace{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// replicas that are not the leader only observe the change through the informer
+	informer.Update(root, updated)
+	if inherited := hierarchy.Inherit(context.Background(), nil, *child); inherited.Annotations["tolerations"] != "group=other" {
+		t.Errorf("expected the updated ancestor after the namespace informer event, got %v", inherited.Annotations)
+	}
+}
+
+func TestAncestorsCycle(t *testing.T) {
+	a := newNamespace("a", "b", map[string]string{"tolerations": "group=a"})
+	b := newNamespace("b", "a", map[string]string{"tolerations": "group=b"})
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(a, b).Build()
+	hierarchy := NewNamespaceHierarchy(c, "parent", hierarchyConfig)
+
+	if _, err := hierarchy.Ancestors(context.Background(), *a); err == nil || !strings.Contains(err.Error(), "a -> b -> a") {
+		t.Errorf("expected a cycle, got %v", err)
+	}
+	recorder := record.NewFakeRecorder(1)
+	inherited := hierarchy.Inherit(context.Background(), recorder, *a)
+	if !reflect.DeepEqual(inherited.Annotations, a.Annotations) {
+		t.Errorf("expected the namespace to be returned as is, got %v", inherited.Annotations)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, "InvalidNamespaceHierarchy") {
+		t.Errorf("expected an InvalidNamespaceHierarchy event, got %s", event)
+	}
+}
+
+func TestDescendants(t *testing.T) {
+	namespaces := []corev1.Namespace{}
+	for _, ns := range []*corev1.Namespace{
+		newNamespace("team", "", nil),
+		newNamespace("team-apps", "team", nil),
+		newNamespace("team-data", "team", nil),
+		newNamespace("team-apps-dev", "team-apps", nil),
+		newNamespace("other", "", nil),
+		newNamespace("x", "y", nil),
+		newNamespace("y", "x", nil),
+	} {
+		namespaces = append(namespaces, *ns)
+	}
+	expected := []string{"team-apps", "team-data", "team-apps-dev"}
+	if found := descendants("team", "parent", namespaces); !reflect.DeepEqual(found, expected) {
+		t.Errorf("expected %v, got %v", expected, found)
+	}
+	if found := descendants("x", "parent", namespaces); !reflect.DeepEqual(found, []string{"y"}) {
+		t.Errorf("expected descendants of a cycle to terminate, got %v", found)
+	}
+}
@@ -0,0 +1,154 @@
+package pod
+
+import (
+	"path"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	dockerHubDomain = "docker.io"
+
+	// RegistryPrefixAnnotation on a namespace overrides the default registry prefix of its pods
+	RegistryPrefixAnnotation = "platform.flanksource.com/registry-prefix"
+	// ImagePullSecretsAnnotation on a namespace is a comma separated list of image pull secrets added to its pods,
+	// instead of the default image pull secret
+	ImagePullSecretsAnnotation = "platform.flanksource.com/image-pull-secrets"
+	// SkipImageRewriteAnnotation on a pod disables rewriting and pinning its images
+	SkipImageRewriteAnnotation = "platform.flanksource.com/skip-image-rewrite"
+)
+
+// rewriteImage returns the image pulled from its registry mirror, or from the default registry prefix when there is no
+// mirror for it. Images are normalized first so that e.g. nginx, docker.io/nginx and docker.io/library/nginx are all
+// rewritten the same way, tags and digests are kept as is.
+func rewriteImage(cfg platformv1.PodMutaterConfig, image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return image, errors.Wrapf(err, "invalid image %s", image)
+	}
+	name := named.Name()
+	if isWhitelistedImage(cfg, image, name) || isMirrored(cfg, name) {
+		return image, nil
+	}
+
+	var to string
+	if mirror, ok := findMirror(cfg.RegistryMirrors, name); ok {
+		to = mirror
+	} else if cfg.DefaultRegistryPrefix != "" {
+		if reference.Domain(named) == dockerHubDomain {
+			to = cfg.DefaultRegistryPrefix + "/" + reference.FamiliarName(named)
+		} else {
+			to = cfg.DefaultRegistryPrefix + "/" + name
+		}
+	} else {
+		return image, nil
+	}
+
+	if tagged, ok := named.(reference.Tagged); ok {
+		to += ":" + tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		to += "@" + digested.Digest().String()
+	}
+	return to, nil
+}
+
+// ParseRegistryMirrors parses a comma separated list of registry=mirror mappings
+func ParseRegistryMirrors(mappings string) (map[string]string, error) {
+	return parseMappings(mappings, "registry mirror", "registry=mirror")
+}
+
+// ParseRegistryPullSecrets parses a comma separated list of registry=secret mappings
+func ParseRegistryPullSecrets(mappings string) (map[string]string, error) {
+	return parseMappings(mappings, "registry pull secret", "registry=secret")
+}
+
+func parseMappings(mappings, kind, format string) (map[string]string, error) {
+	parsed := map[string]string{}
+	for _, mapping := range strings.Split(mappings, ",") {
+		if mapping == "" {
+			continue
+		}
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.Errorf("invalid %s %s, expected %s", kind, mapping, format)
+		}
+		parsed[parts[0]] = parts[1]
+	}
+	return parsed, nil
+}
+
+func isWhitelistedImage(cfg platformv1.PodMutaterConfig, image, name string) bool {
+	for _, reg := range cfg.RegistryWhitelist {
+		if reg != "" && (strings.HasPrefix(image, reg) || strings.HasPrefix(name, reg)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isMirrored returns true if the image is already pulled from a mirror or the default registry prefix
+func isMirrored(cfg platformv1.PodMutaterConfig, name string) bool {
+	if cfg.DefaultRegistryPrefix != "" && hasPathPrefix(name, cfg.DefaultRegistryPrefix) {
+		return true
+	}
+	for _, mirror := range cfg.RegistryMirrors {
+		if hasPathPrefix(name, mirror) {
+			return true
+		}
+	}
+	return false
+}
+
+// findMirror returns the repository name rewritten using the most specific matching mirror. A mirror matches the
+// leading path components of the normalized name, each of which can be a glob (e.g. *.gcr.io), while a trailing /*
+// matches any repository below it.
+func findMirror(mirrors map[string]string, name string) (string, bool) {
+	pattern, length := longestMatch(mirrors, name)
+	if length == 0 {
+		return "", false
+	}
+	rest := strings.Split(name, "/")[length:]
+	return strings.Join(append([]string{strings.TrimSuffix(mirrors[pattern], "/")}, rest...), "/"), true
+}
+
+// findPullSecret returns the image pull secret of the most specific registry matching the normalized name, registries
+// are matched the same way as mirrors
+func findPullSecret(secrets map[string]string, name string) (string, bool) {
+	pattern, length := longestMatch(secrets, name)
+	return secrets[pattern], length > 0
+}
+
+// longestMatch returns the pattern matching the most leading path components of name and the number of components
+func longestMatch(patterns map[string]string, name string) (string, int) {
+	components := strings.Split(name, "/")
+	bestPattern, bestLength := "", 0
+	for pattern := range patterns {
+		patternComponents := strings.Split(strings.TrimSuffix(pattern, "/*"), "/")
+		if len(patternComponents) > len(components) || !matchComponents(patternComponents, components) {
+			continue
+		}
+		if len(patternComponents) < bestLength || (len(patternComponents) == bestLength && pattern > bestPattern) {
+			continue
+		}
+		bestPattern, bestLength = pattern, len(patternComponents)
+	}
+	return bestPattern, bestLength
+}
+
+func matchComponents(patterns, components []string) bool {
+	for i, pattern := range patterns {
+		if ok, err := path.Match(pattern, components[i]); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func hasPathPrefix(name, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return name == prefix || strings.HasPrefix(name, prefix+"/")
+}
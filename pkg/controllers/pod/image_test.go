@@ -0,0 +1,84 @@
+package pod
+
+import (
+	"testing"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+)
+
+const testDigest = "sha256:0a2b8d4d6c35e4d0d7f0b1e2a2b1c8a4b5f1e5f6a7e8f9a0b1c2d3e4f5a6b7c8"
+
+func TestRewriteImage(t *testing.T) {
+	mirrors, err := ParseRegistryMirrors("docker.io=mirror.corp/dockerhub,quay.io=mirror.corp/quay,gcr.io/*=mirror.corp/gcr,*.gcr.io=mirror.corp/gcr-regional,quay.io/coreos=mirror.corp/coreos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := platformv1.PodMutaterConfig{
+		RegistryMirrors:   mirrors,
+		RegistryWhitelist: []string{"whitelist", ""},
+	}
+	fixtures := map[string]string{
+		"nginx":                                    "mirror.corp/dockerhub/library/nginx",
+		"nginx:1.19":                               "mirror.corp/dockerhub/library/nginx:1.19",
+		"docker.io/nginx:1.19":                     "mirror.corp/dockerhub/library/nginx:1.19",
+		"docker.io/library/nginx:1.19":             "mirror.corp/dockerhub/library/nginx:1.19",
+		"index.docker.io/library/nginx:1.19":       "mirror.corp/dockerhub/library/nginx:1.19",
+		"nginx@" + testDigest:                      "mirror.corp/dockerhub/library/nginx@" + testDigest,
+		"nginx:1.19@" + testDigest:                 "mirror.corp/dockerhub/library/nginx:1.19@" + testDigest,
+		"quay.io/prometheus/node-exporter:v1.0.1":  "mirror.corp/quay/prometheus/node-exporter:v1.0.1",
+		"quay.io/coreos/etcd:v3.4":                 "mirror.corp/coreos/etcd:v3.4",
+		"gcr.io/google-containers/pause:3.2":       "mirror.corp/gcr/google-containers/pause:3.2",
+		"eu.gcr.io/project/app:1":                  "mirror.corp/gcr-regional/project/app:1",
+		"registry.corp:5000/app:1":                 "registry.corp:5000/app:1",
+		"mirror.corp/dockerhub/library/nginx:1.19": "mirror.corp/dockerhub/library/nginx:1.19",
+		"whitelist/busybox:latest":                 "whitelist/busybox:latest",
+		"Invalid:Image":                            "Invalid:Image",
+	}
+
+	for image, expected := range fixtures {
+		t.Run(image, func(t *testing.T) {
+			to, _ := rewriteImage(cfg, image)
+			if to != expected {
+				t.Errorf("expected %s, got %s", expected, to)
+			}
+		})
+	}
+}
+
+func TestRewriteImageWithPrefix(t *testing.T) {
+	cfg := platformv1.PodMutaterConfig{
+		DefaultRegistryPrefix: "registry.corp",
+		RegistryMirrors:       map[string]string{"quay.io": "mirror.corp/quay"},
+	}
+	fixtures := map[string]string{
+		"busybox:latest":                       "registry.corp/busybox:latest",
+		"docker.io/library/busybox:latest":     "registry.corp/busybox:latest",
+		"org/app@" + testDigest:                "registry.corp/org/app@" + testDigest,
+		"k8s.gcr.io/pause:3.2":                 "registry.corp/k8s.gcr.io/pause:3.2",
+		"registry.corp:5000/app:1":             "registry.corp/registry.corp:5000/app:1",
+		"registry.corp/busybox:latest":         "registry.corp/busybox:latest",
+		"quay.io/prometheus/prometheus:v2.0.0": "mirror.corp/quay/prometheus/prometheus:v2.0.0",
+	}
+
+	for image, expected := range fixtures {
+		t.Run(image, func(t *testing.T) {
+			to, err := rewriteImage(cfg, image)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if to != expected {
+				t.Errorf("expected %s, got %s", expected, to)
+			}
+		})
+	}
+}
+
+func TestParseRegistryMirrors(t *testing.T) {
+	if _, err := ParseRegistryMirrors("docker.io"); err == nil {
+		t.Error("expected a mapping without a mirror to be invalid")
+	}
+	mirrors, err := ParseRegistryMirrors("")
+	if err != nil || len(mirrors) != 0 {
+		t.Errorf("expected no mirrors, got %v %v", mirrors, err)
+	}
+}
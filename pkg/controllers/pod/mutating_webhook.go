@@ -3,122 +3,346 @@ package pod
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"strings"
 
+	"github.com/docker/distribution/reference"
 	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
+// ephemeralContainersSubResource is used by e.g. kubectl debug to add ephemeral containers to running pods
+const ephemeralContainersSubResource = "ephemeralcontainers"
+
 type podHandler struct {
 	Client client.Client
+	// reader reads image pull secrets without caching them
+	reader client.Reader
 	*admission.Decoder
-	Log logr.Logger
+	Log      logr.Logger
+	Recorder record.EventRecorder
 	platformv1.PodMutaterConfig
+	digests *digestResolver
+	// hierarchy merges the annotations of the ancestors of namespaces
+	hierarchy *NamespaceHierarchy
+	// nativeSidecars are the names of the native sidecars injected by UpdatePod
+	nativeSidecars []string
 }
 
-//+kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,sideEffects=None,admissionReviewVersions=v1,failurePolicy=ignore,groups="",resources=pods,verbs=create;update,versions=v1,name=mutate-pods-v1.platform.flanksource.com
-func NewMutatingWebhook(client client.Client, cfg platformv1.PodMutaterConfig) *admission.Webhook {
-	cfg.AnnotationsMap = make(map[string]bool)
-	for _, a := range cfg.Annotations {
-		cfg.AnnotationsMap[a] = true
-	}
+//+kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,sideEffects=None,admissionReviewVersions=v1,failurePolicy=ignore,groups="",resources=pods;pods/ephemeralcontainers,verbs=create;update,versions=v1,name=mutate-pods-v1.platform.flanksource.com
+func NewMutatingWebhook(client client.Client, reader client.Reader, recorder record.EventRecorder, cfg platformv1.PodMutaterConfig, hierarchy *NamespaceHierarchy) *admission.Webhook {
+	cfg.AnnotationsMap = annotationsMap(cfg.Annotations)
 	decoder, _ := admission.NewDecoder(client.Scheme())
-	return &admission.Webhook{
-		Handler: &podHandler{
-			Decoder:          decoder,
-			Client:           client,
-			PodMutaterConfig: cfg,
-			Log:              logf.Log.WithName("pod-mutator")},
+	handler := &podHandler{
+		Decoder:          decoder,
+		Client:           client,
+		reader:           reader,
+		Recorder:         recorder,
+		PodMutaterConfig: cfg,
+		hierarchy:        hierarchy,
+		Log:              logf.Log.WithName("pod-mutator")}
+	if cfg.PinImageDigests {
+		handler.digests = newDigestResolver(&http.Client{Timeout: digestTimeout}, cfg.ImageDigestCacheTTL)
 	}
+	return &admission.Webhook{Handler: handler}
 }
 
 func (handler *podHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
-	pod := &corev1.Pod{}
-	err := handler.Decode(req, pod)
+	if req.SubResource != "" && req.SubResource != ephemeralContainersSubResource {
+		return admission.Allowed("")
+	}
+
+	namespace, err := getNamespace(ctx, handler.Client, req.Namespace)
 	if err != nil {
 		return admission.Errored(http.StatusBadRequest, err)
 	}
+	namespace = handler.hierarchy.Inherit(ctx, handler.Recorder, namespace)
 
-	namespace := corev1.Namespace{}
-	if err := handler.Client.Get(ctx, types.NamespacedName{Name: req.Namespace}, &namespace); err != nil {
-		return admission.Errored(http.StatusBadRequest, errors.Wrapf(err, "failed to get namespace %s", pod.Namespace))
+	if req.Kind.Kind == "EphemeralContainers" {
+		return handler.handleEphemeralContainers(ctx, req, namespace)
+	}
+
+	pod := &corev1.Pod{}
+	if err := handler.Decode(req, pod); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	old := &corev1.Pod{}
+	if req.Operation == admissionv1.Update {
+		if err := handler.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
 	}
 
-	pod = handler.UpdatePod(namespace, pod)
+	cfg, err := resolveConfig(ctx, handler.Client, handler.PodMutaterConfig, namespace, pod)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	resolved := *handler
+	resolved.PodMutaterConfig = cfg
+	switch {
+	case req.SubResource == ephemeralContainersSubResource:
+		if pod.Annotations[SkipImageRewriteAnnotation] != "true" {
+			pod.Spec.EphemeralContainers = resolved.UpdateEphemeralContainers(old.Spec.EphemeralContainers, pod.Spec.EphemeralContainers)
+		}
+	case req.Operation == admissionv1.Update:
+		pod = resolved.UpdateExistingPod(ctx, namespace, old, pod)
+	default:
+		pod, err = resolved.UpdatePod(ctx, namespace, pod)
+		if err != nil {
+			return admission.Denied(err.Error())
+		}
+	}
 
 	marshaledPod, err := json.Marshal(pod)
 	if err != nil {
 		return admission.Errored(http.StatusInternalServerError, errors.Wrapf(err, "Failed to marshal pod"))
 	}
+	if marshaledPod, err = setRestartPolicies(req.Object.Raw, marshaledPod, resolved.nativeSidecars); err != nil {
+		return admission.Errored(http.StatusInternalServerError, errors.Wrapf(err, "Failed to set restart policies"))
+	}
 	response := admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
 	return response
 }
 
-func (handler *podHandler) UpdateContainer(container v1.Container) v1.Container {
-	whitelisted := false
-	for _, reg := range handler.RegistryWhitelist {
-		if strings.HasPrefix(container.Image, reg) {
-			whitelisted = true
-			break
-		}
+// getNamespace returns the namespace of an admission request, shared by the pod webhooks
+func getNamespace(ctx context.Context, c client.Client, name string) (corev1.Namespace, error) {
+	namespace := corev1.Namespace{}
+	if err := c.Get(ctx, types.NamespacedName{Name: name}, &namespace); err != nil {
+		return namespace, errors.Wrapf(err, "failed to get namespace %s", name)
 	}
-	if !whitelisted {
-		to := fmt.Sprintf("%s/%s", handler.DefaultRegistryPrefix, container.Image)
-		handler.Log.Info("Updating image", "from", container.Image, "to", to)
-		container.Image = to
+	return namespace, nil
+}
+
+// handleEphemeralContainers mutates the EphemeralContainers objects sent to the ephemeralcontainers subresource by
+// Kubernetes versions before 1.22, which do not include the pod itself
+func (handler *podHandler) handleEphemeralContainers(ctx context.Context, req admission.Request, namespace corev1.Namespace) admission.Response {
+	containers, old := &corev1.EphemeralContainers{}, &corev1.EphemeralContainers{}
+	if err := handler.Decode(req, containers); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if err := handler.DecodeRaw(req.OldObject, old); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	pod := &corev1.Pod{}
+	if err := handler.Client.Get(ctx, types.NamespacedName{Namespace: req.Namespace, Name: req.Name}, pod); err != nil {
+		return admission.Errored(http.StatusInternalServerError, errors.Wrapf(err, "failed to get pod %s/%s", req.Namespace, req.Name))
+	}
+	if pod.Annotations[SkipImageRewriteAnnotation] == "true" {
+		return admission.Allowed("")
 	}
 
+	cfg, err := resolveConfig(ctx, handler.Client, handler.PodMutaterConfig, namespace, pod)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	resolved := *handler
+	resolved.PodMutaterConfig = cfg
+	containers.EphemeralContainers = resolved.UpdateEphemeralContainers(old.EphemeralContainers, containers.EphemeralContainers)
+
+	marshaled, err := json.Marshal(containers)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, errors.Wrapf(err, "Failed to marshal ephemeral containers"))
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+func (handler *podHandler) UpdateContainer(container v1.Container) v1.Container {
+	container.Image = handler.UpdateImage(container.Image)
 	return container
 }
 
-func (handler *podHandler) UpdatePod(namespace v1.Namespace, pod *v1.Pod) *v1.Pod {
-	pod, _ = UpdateAnnotations(namespace, handler.PodMutaterConfig, pod)
-	pod = handler.UpdateSecrets(pod)
+func (handler *podHandler) UpdateImage(image string) string {
+	to, err := rewriteImage(handler.PodMutaterConfig, image)
+	if err != nil {
+		handler.Log.Error(err, "Not updating image", "image", image)
+		return image
+	}
+	if to != image {
+		handler.Log.Info("Updating image", "from", image, "to", to)
+	}
+	return to
+}
+
+func (handler *podHandler) UpdatePod(ctx context.Context, namespace v1.Namespace, pod *v1.Pod) (*v1.Pod, error) {
+	// labels are inherited first, as annotation templates are rendered with the labels the reconciler sees
+	pod, _ = UpdateLabels(namespace, handler.PodMutaterConfig, pod)
+	pod, _, errs := UpdateAnnotations(ctx, namespace, handler.PodMutaterConfig, pod)
+	recordAnnotationErrors(handler.Recorder, namespace, pod, errs)
 	pod = handler.UpdateTolerations(namespace, pod)
-	pod.Spec.Containers = handler.UpdateContainers(pod.Spec.Containers)
-	pod.Spec.InitContainers = handler.UpdateContainers(pod.Spec.InitContainers)
+	pod, err := handler.UpdateNodeSelector(namespace, pod)
+	if err != nil {
+		return pod, err
+	}
+	if handler.nativeSidecars, err = handler.InjectSidecars(ctx, namespace, pod); err != nil {
+		return pod, err
+	}
+	pod = handler.UpdateEnv(ctx, namespace.Name, pod)
+	pod = handler.UpdateSecurityContext(namespace, pod)
+	if pod, err = handler.UpdateResources(pod); err != nil {
+		return pod, err
+	}
+	skipImageRewrite := pod.Annotations[SkipImageRewriteAnnotation] == "true"
+	if !skipImageRewrite {
+		pod.Spec.Containers = handler.UpdateContainers(pod.Spec.Containers)
+		pod.Spec.InitContainers = handler.UpdateContainers(pod.Spec.InitContainers)
+	}
+	// secrets are added once images are rewritten, as the registries they are pulled from determine the secrets needed
+	pod = handler.UpdateSecrets(ctx, namespace.Name, pod)
+	if skipImageRewrite {
+		return pod, nil
+	}
+	if handler.digests != nil {
+		if err := handler.PinDigests(ctx, namespace.Name, pod); err != nil {
+			return pod, err
+		}
+	}
+	return pod, nil
+}
+
+// UpdateExistingPod limits the mutation of a pod update to the fields that can be changed once a pod is created, i.e.
+// annotations and labels are inherited and only images changed by the update itself are rewritten. Images are not pinned.
+func (handler *podHandler) UpdateExistingPod(ctx context.Context, namespace v1.Namespace, old, pod *v1.Pod) *v1.Pod {
+	// labels are inherited first, as annotation templates are rendered with the labels the reconciler sees
+	pod, _ = UpdateLabels(namespace, handler.PodMutaterConfig, pod)
+	releaseChangedAnnotations(ctx, namespace, old, pod)
+	pod, _, errs := UpdateAnnotations(ctx, namespace, handler.PodMutaterConfig, pod)
+	recordAnnotationErrors(handler.Recorder, namespace, pod, errs)
+	if pod.Annotations[SkipImageRewriteAnnotation] == "true" {
+		return pod
+	}
+	pod.Spec.Containers = handler.updateChangedContainers(old.Spec.Containers, pod.Spec.Containers)
+	pod.Spec.InitContainers = handler.updateChangedContainers(old.Spec.InitContainers, pod.Spec.InitContainers)
 	return pod
 }
 
+func (handler *podHandler) updateChangedContainers(old, containers []v1.Container) []v1.Container {
+	images := map[string]string{}
+	for _, container := range old {
+		images[container.Name] = container.Image
+	}
+	for i, container := range containers {
+		if image, ok := images[container.Name]; !ok || image != container.Image {
+			containers[i] = handler.UpdateContainer(container)
+		}
+	}
+	return containers
+}
+
+// UpdateEphemeralContainers rewrites the images of ephemeral containers being added, existing ones cannot be changed
+func (handler *podHandler) UpdateEphemeralContainers(old, containers []v1.EphemeralContainer) []v1.EphemeralContainer {
+	existing := map[string]bool{}
+	for _, container := range old {
+		existing[container.Name] = true
+	}
+	for i, container := range containers {
+		if !existing[container.Name] {
+			containers[i].Image = handler.UpdateImage(container.Image)
+		}
+	}
+	return containers
+}
+
 func (handler *podHandler) UpdateTolerations(namespace v1.Namespace, pod *v1.Pod) *v1.Pod {
-	tolerations := pod.Spec.Tolerations
 	tolerationsValue := namespace.GetAnnotations()[handler.PodMutaterConfig.TolerationsAnnotation]
-	for _, toleration := range strings.Split(tolerationsValue, ";") {
-		if toleration == "" {
+	if tolerationsValue == "" {
+		return pod
+	}
+	tolerations, errs := ParseTolerations(tolerationsValue)
+	for _, err := range errs {
+		handler.Log.Error(err, "Ignoring toleration", "namespace", namespace.Name)
+		if handler.Recorder != nil {
+			handler.Recorder.Eventf(&namespace, corev1.EventTypeWarning, "InvalidToleration", "Ignoring %s annotation entry: %s", handler.TolerationsAnnotation, err)
+		}
+	}
+	for _, toleration := range tolerations {
+		if hasToleration(pod.Spec.Tolerations, toleration) {
 			continue
 		}
-		key := strings.Split(toleration, "=")[0]
-		value := strings.Split(toleration, "=")[1]
-		value = strings.Split(value, ":")[0]
-		handler.Log.Info("Adding toleration", "pod", pod.GetName(), key, value)
-		tolerations = append(tolerations, v1.Toleration{
-			Key: key, Value: value,
-			Effect: v1.TaintEffectNoSchedule,
-		})
-	}
-	pod.Spec.Tolerations = tolerations
+		handler.Log.Info("Adding toleration", "pod", pod.GetName(), "key", toleration.Key, "value", toleration.Value, "effect", toleration.Effect)
+		pod.Spec.Tolerations = append(pod.Spec.Tolerations, toleration)
+	}
 	return pod
 }
 
-func (handler *podHandler) UpdateSecrets(pod *v1.Pod) *v1.Pod {
-	if len(pod.Spec.ImagePullSecrets) == 0 && handler.DefaultImagePullSecret != "" {
+// UpdateSecrets adds the image pull secrets of the namespace, or the default image pull secret to pods without any,
+// and then merges in the image pull secrets of the registries the images are pulled from
+func (handler *podHandler) UpdateSecrets(ctx context.Context, namespace string, pod *v1.Pod) *v1.Pod {
+	if len(handler.ImagePullSecrets) > 0 {
+		for _, name := range handler.ImagePullSecrets {
+			if hasImagePullSecret(pod, name) || !handler.secretExists(ctx, namespace, name) {
+				continue
+			}
+			handler.Log.Info("Injecting image pull secret", "name", name)
+			pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+		}
+	} else if len(pod.Spec.ImagePullSecrets) == 0 && handler.DefaultImagePullSecret != "" && handler.secretExists(ctx, namespace, handler.DefaultImagePullSecret) {
 		handler.Log.Info("Injecting image pull secret", "name", handler.DefaultImagePullSecret)
 		pod.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{
 			Name: handler.DefaultImagePullSecret,
 		}}
 	}
+	return handler.updateRegistrySecrets(ctx, namespace, pod)
+}
+
+// updateRegistrySecrets merges the image pull secrets of the registries the images of the pod are pulled from into the
+// existing image pull secrets of the pod
+func (handler *podHandler) updateRegistrySecrets(ctx context.Context, namespace string, pod *v1.Pod) *v1.Pod {
+	if len(handler.RegistryPullSecrets) == 0 {
+		return pod
+	}
+	images := []string{}
+	for _, container := range pod.Spec.InitContainers {
+		images = append(images, container.Image)
+	}
+	for _, container := range pod.Spec.Containers {
+		images = append(images, container.Image)
+	}
+	for _, image := range images {
+		named, err := reference.ParseNormalizedNamed(image)
+		if err != nil {
+			continue
+		}
+		name, ok := findPullSecret(handler.RegistryPullSecrets, named.Name())
+		if !ok || hasImagePullSecret(pod, name) || !handler.secretExists(ctx, namespace, name) {
+			continue
+		}
+		handler.Log.Info("Injecting image pull secret", "name", name, "image", image)
+		pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+	}
 	return pod
 }
 
+// secretExists returns false when VerifyImagePullSecrets is set and the secret has not been created in the namespace
+// yet, e.g. before it is replicated into a new namespace
+func (handler *podHandler) secretExists(ctx context.Context, namespace, name string) bool {
+	if !handler.VerifyImagePullSecrets {
+		return true
+	}
+	secret := corev1.Secret{}
+	if err := handler.reader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &secret); err != nil {
+		handler.Log.Info("Not injecting image pull secret", "namespace", namespace, "name", name, "reason", err.Error())
+		return false
+	}
+	return true
+}
+
+func hasImagePullSecret(pod *v1.Pod, name string) bool {
+	for _, secret := range pod.Spec.ImagePullSecrets {
+		if secret.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (handler *podHandler) UpdateContainers(containers []v1.Container) []v1.Container {
 	_containers := []v1.Container{}
 	for _, container := range containers {
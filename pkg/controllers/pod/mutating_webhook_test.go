@@ -0,0 +1,175 @@
+package pod
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestUpdatePodSecretsAndOptOut(t *testing.T) {
+	handler := &podHandler{
+		Log: logf.Log,
+		PodMutaterConfig: platformv1.PodMutaterConfig{
+			DefaultRegistryPrefix:  "registry.corp",
+			DefaultImagePullSecret: "default",
+			ImagePullSecrets:       []string{"team-a", "shared"},
+		},
+	}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "shared"}},
+		Containers:       []corev1.Container{{Name: "app", Image: "nginx:1.19"}},
+	}}
+
+	pod, err := handler.UpdatePod(context.Background(), corev1.Namespace{}, pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := []string{}
+	for _, secret := range pod.Spec.ImagePullSecrets {
+		secrets = append(secrets, secret.Name)
+	}
+	if len(secrets) != 2 || secrets[0] != "shared" || secrets[1] != "team-a" {
+		t.Errorf("expected the namespace pull secrets to be added once, got %v", secrets)
+	}
+	if pod.Spec.Containers[0].Image != "registry.corp/nginx:1.19" {
+		t.Errorf("expected the image to be rewritten, got %s", pod.Spec.Containers[0].Image)
+	}
+
+	optOut := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{SkipImageRewriteAnnotation: "true"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx:1.19"}}},
+	}
+	optOut, err = handler.UpdatePod(context.Background(), corev1.Namespace{}, optOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if optOut.Spec.Containers[0].Image != "nginx:1.19" {
+		t.Errorf("expected the image of an opted out pod to be kept, got %s", optOut.Spec.Containers[0].Image)
+	}
+}
+
+func TestUpdateExistingPod(t *testing.T) {
+	handler := &podHandler{
+		Log:              logf.Log,
+		PodMutaterConfig: platformv1.PodMutaterConfig{DefaultRegistryPrefix: "registry.corp", DefaultImagePullSecret: "default"},
+	}
+	old := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "app", Image: "nginx:1.19"},
+		{Name: "sidecar", Image: "busybox:1.32"},
+	}}}
+	pod := old.DeepCopy()
+	pod.Spec.Containers[1].Image = "busybox:1.33"
+
+	pod = handler.UpdateExistingPod(context.Background(), corev1.Namespace{}, old, pod)
+	if pod.Spec.Containers[0].Image != "nginx:1.19" {
+		t.Errorf("expected an unchanged image to be kept, got %s", pod.Spec.Containers[0].Image)
+	}
+	if pod.Spec.Containers[1].Image != "registry.corp/busybox:1.33" {
+		t.Errorf("expected a changed image to be rewritten, got %s", pod.Spec.Containers[1].Image)
+	}
+	if len(pod.Spec.ImagePullSecrets) != 0 {
+		t.Errorf("expected the pull secrets of an existing pod to be kept, got %v", pod.Spec.ImagePullSecrets)
+	}
+}
+
+func TestUpdateEphemeralContainers(t *testing.T) {
+	handler := &podHandler{Log: logf.Log, PodMutaterConfig: platformv1.PodMutaterConfig{DefaultRegistryPrefix: "registry.corp"}}
+	old := []corev1.EphemeralContainer{
+		{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger-1", Image: "busybox"}},
+	}
+	containers := append([]corev1.EphemeralContainer{}, old...)
+	containers = append(containers, corev1.EphemeralContainer{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger-2", Image: "busybox"}})
+
+	containers = handler.UpdateEphemeralContainers(old, containers)
+	if containers[0].Image != "busybox" {
+		t.Errorf("expected an existing ephemeral container to be kept, got %s", containers[0].Image)
+	}
+	if containers[1].Image != "registry.corp/busybox" {
+		t.Errorf("expected a new ephemeral container to be rewritten, got %s", containers[1].Image)
+	}
+}
+
+func TestUpdateSecretsVerifiesExistence(t *testing.T) {
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "replicated"}}
+	handler := &podHandler{
+		reader:           fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(secret).Build(),
+		Log:              logf.Log,
+		PodMutaterConfig: platformv1.PodMutaterConfig{DefaultImagePullSecret: "registry", VerifyImagePullSecrets: true},
+	}
+
+	if pod := handler.UpdateSecrets(context.Background(), "new", &corev1.Pod{}); len(pod.Spec.ImagePullSecrets) != 0 {
+		t.Errorf("expected a missing secret not to be referenced, got %v", pod.Spec.ImagePullSecrets)
+	}
+	if pod := handler.UpdateSecrets(context.Background(), "replicated", &corev1.Pod{}); len(pod.Spec.ImagePullSecrets) != 1 {
+		t.Errorf("expected an existing secret to be referenced, got %v", pod.Spec.ImagePullSecrets)
+	}
+}
+
+func TestUpdatePodRegistryPullSecrets(t *testing.T) {
+	secrets, err := ParseRegistryPullSecrets("registry.corp=corp-pull,ghcr.io=ghcr-pull,ghcr.io/team=team-pull")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &podHandler{
+		Log:              logf.Log,
+		PodMutaterConfig: platformv1.PodMutaterConfig{DefaultRegistryPrefix: "registry.corp", RegistryWhitelist: []string{"ghcr.io"}, RegistryPullSecrets: secrets},
+	}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "corp-pull"}},
+		InitContainers:   []corev1.Container{{Name: "init", Image: "ghcr.io/team/init:1.0"}},
+		Containers: []corev1.Container{
+			{Name: "app", Image: "nginx:1.19"},
+			{Name: "tool", Image: "ghcr.io/org/tool:1.0"},
+			{Name: "other", Image: "ghcr.io/org/other:1.0"},
+		},
+	}}
+
+	pod, err = handler.UpdatePod(context.Background(), corev1.Namespace{}, pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, secret := range pod.Spec.ImagePullSecrets {
+		names = append(names, secret.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"corp-pull", "team-pull", "ghcr-pull"}) {
+		t.Errorf("expected the registry pull secrets to be merged without duplicates, got %v", names)
+	}
+}
+
+func TestUpdateSecretsMergesRegistryPullSecrets(t *testing.T) {
+	secrets, err := ParseRegistryPullSecrets("ghcr.io=ghcr-pull")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPod := func() *corev1.Pod {
+		return &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "ghcr.io/org/app:1.0"}}}}
+	}
+	names := func(pod *corev1.Pod) []string {
+		names := []string{}
+		for _, secret := range pod.Spec.ImagePullSecrets {
+			names = append(names, secret.Name)
+		}
+		return names
+	}
+
+	handler := &podHandler{
+		Log:              logf.Log,
+		PodMutaterConfig: platformv1.PodMutaterConfig{ImagePullSecrets: []string{"namespace-pull"}, RegistryPullSecrets: secrets},
+	}
+	if pod := handler.UpdateSecrets(context.Background(), "default", newPod()); !reflect.DeepEqual(names(pod), []string{"namespace-pull", "ghcr-pull"}) {
+		t.Errorf("expected the namespace and registry pull secrets, got %v", names(pod))
+	}
+
+	handler.PodMutaterConfig = platformv1.PodMutaterConfig{DefaultImagePullSecret: "default-pull", RegistryPullSecrets: secrets}
+	if pod := handler.UpdateSecrets(context.Background(), "default", newPod()); !reflect.DeepEqual(names(pod), []string{"default-pull", "ghcr-pull"}) {
+		t.Errorf("expected the default and registry pull secrets, got %v", names(pod))
+	}
+}
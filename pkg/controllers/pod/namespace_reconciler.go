@@ -8,6 +8,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -18,29 +19,35 @@ import (
 
 type NamespaceReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
 
 	// interval is the time after which the controller requeue the reconcile key
 	interval time.Duration
 	cfg      platformv1.PodMutaterConfig
+	// hierarchy merges the annotations of the ancestors of namespaces
+	hierarchy *NamespaceHierarchy
 }
 
-func newNamespaceReconciler(mgr manager.Manager, interval time.Duration, cfg platformv1.PodMutaterConfig) reconcile.Reconciler {
+func newNamespaceReconciler(mgr manager.Manager, interval time.Duration, cfg platformv1.PodMutaterConfig, hierarchy *NamespaceHierarchy) reconcile.Reconciler {
 	return &NamespaceReconciler{
-		Client:   mgr.GetClient(),
-		Scheme:   mgr.GetScheme(),
-		interval: interval,
-		cfg:      cfg,
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor(name),
+		interval:  interval,
+		cfg:       cfg,
+		hierarchy: hierarchy,
 	}
 }
 
-func addNamespaceReconciler(mgr manager.Manager, r reconcile.Reconciler) error {
+// addNamespaceReconciler watches namespaces, a change to a namespace also reconciles its descendants which inherit from it
+func addNamespaceReconciler(mgr manager.Manager, r reconcile.Reconciler, hierarchy *NamespaceHierarchy) error {
 	c, err := controller.New(name, mgr, controller.Options{Reconciler: r})
 	if err != nil {
 		return err
 	}
 
-	return c.Watch(&source.Kind{Type: &corev1.Namespace{}}, &handler.EnqueueRequestForObject{})
+	return c.Watch(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(hierarchy.namespaceAndDescendants))
 }
 
 // +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list
@@ -54,13 +61,21 @@ func (r *NamespaceReconciler) Reconcile(ctx context.Context, request reconcile.R
 		}
 		return reconcile.Result{}, err
 	}
+	ns = r.hierarchy.Inherit(ctx, r.Recorder, ns)
 
 	podList := corev1.PodList{}
 	if err := r.Client.List(ctx, &podList, client.InNamespace(request.Name)); err != nil {
 		return reconcile.Result{}, err
 	}
 
-	changedPods := RequiresAnnotationUpdate(ns, r.cfg, podList.Items...)
+	changedPods := []corev1.Pod{}
+	for _, pod := range podList.Items {
+		cfg, err := resolveConfig(ctx, r.Client, r.cfg, ns, &pod)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		changedPods = append(changedPods, RequiresAnnotationUpdate(ctx, ns, cfg, r.Recorder, pod)...)
+	}
 
 	for _, pod := range changedPods {
 		if err := r.Client.Update(ctx, &pod); err != nil {
@@ -0,0 +1,127 @@
+package pod
+
+import (
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/yaml"
+)
+
+// UpdateNodeSelector merges the node selector of the namespace into the pod, like the PodNodeSelector admission plugin.
+// Equality requirements (key=value) are added to the node selector of the pod and set based requirements
+// (key in (a,b), key notin (a), key, !key) to each required node affinity term. An error is returned when the node
+// selector of the pod contradicts the namespace, or is not allowed by the whitelist of the namespace.
+func (handler *podHandler) UpdateNodeSelector(namespace corev1.Namespace, pod *corev1.Pod) (*corev1.Pod, error) {
+	value := namespace.GetAnnotations()[handler.NodeSelectorAnnotation]
+	whitelist, whitelisted := handler.NodeSelectorWhitelist[namespace.Name]
+	if value == "" && !whitelisted {
+		return pod, nil
+	}
+	selector, err := labels.Parse(value)
+	if err != nil {
+		return pod, errors.Wrapf(err, "invalid %s annotation on namespace %s", handler.NodeSelectorAnnotation, namespace.Name)
+	}
+	requirements, _ := selector.Requirements()
+
+	expressions := []corev1.NodeSelectorRequirement{}
+	for _, requirement := range requirements {
+		if podValue, ok := pod.Spec.NodeSelector[requirement.Key()]; ok && !requirement.Matches(labels.Set{requirement.Key(): podValue}) {
+			return pod, errors.Errorf("node selector %s=%s conflicts with the node selector %s of namespace %s", requirement.Key(), podValue, value, namespace.Name)
+		}
+		switch requirement.Operator() {
+		case selection.Equals, selection.DoubleEquals:
+			if pod.Spec.NodeSelector == nil {
+				pod.Spec.NodeSelector = map[string]string{}
+			}
+			pod.Spec.NodeSelector[requirement.Key()] = requirement.Values().List()[0]
+		default:
+			expressions = append(expressions, nodeSelectorRequirement(requirement))
+		}
+	}
+	if len(expressions) > 0 {
+		addRequiredNodeAffinity(pod, expressions)
+	}
+
+	if whitelisted && !isNodeSelectorWhitelisted(pod.Spec.NodeSelector, whitelist) {
+		return pod, errors.Errorf("node selector %s is not allowed in namespace %s, allowed are %s", labels.Set(pod.Spec.NodeSelector), namespace.Name, labels.Set(whitelist))
+	}
+	return pod, nil
+}
+
+// isNodeSelectorWhitelisted returns true when every label of the node selector is in the whitelist with the same value
+func isNodeSelectorWhitelisted(nodeSelector, whitelist map[string]string) bool {
+	for key, value := range nodeSelector {
+		if allowed, ok := whitelist[key]; !ok || allowed != value {
+			return false
+		}
+	}
+	return true
+}
+
+func nodeSelectorRequirement(requirement labels.Requirement) corev1.NodeSelectorRequirement {
+	operators := map[selection.Operator]corev1.NodeSelectorOperator{
+		selection.In:           corev1.NodeSelectorOpIn,
+		selection.NotIn:        corev1.NodeSelectorOpNotIn,
+		selection.NotEquals:    corev1.NodeSelectorOpNotIn,
+		selection.Exists:       corev1.NodeSelectorOpExists,
+		selection.DoesNotExist: corev1.NodeSelectorOpDoesNotExist,
+		selection.GreaterThan:  corev1.NodeSelectorOpGt,
+		selection.LessThan:     corev1.NodeSelectorOpLt,
+	}
+	var values []string
+	if requirement.Values().Len() > 0 {
+		values = requirement.Values().List()
+	}
+	return corev1.NodeSelectorRequirement{Key: requirement.Key(), Operator: operators[requirement.Operator()], Values: values}
+}
+
+// addRequiredNodeAffinity adds the expressions to every required node selector term, as terms are ORed
+func addRequiredNodeAffinity(pod *corev1.Pod, expressions []corev1.NodeSelectorRequirement) {
+	if pod.Spec.Affinity == nil {
+		pod.Spec.Affinity = &corev1.Affinity{}
+	}
+	if pod.Spec.Affinity.NodeAffinity == nil {
+		pod.Spec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	affinity := pod.Spec.Affinity.NodeAffinity
+	if affinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		affinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{}
+	}
+	required := affinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(required.NodeSelectorTerms) == 0 {
+		required.NodeSelectorTerms = []corev1.NodeSelectorTerm{{}}
+	}
+	for i := range required.NodeSelectorTerms {
+		required.NodeSelectorTerms[i].MatchExpressions = append(required.NodeSelectorTerms[i].MatchExpressions, expressions...)
+	}
+}
+
+// LoadNodeSelectorWhitelist reads a YAML file of the node selector labels pods are allowed to use per namespace, e.g.
+//
+//	team-a: node.kubernetes.io/group=team-a
+//	team-b: node.kubernetes.io/group=team-b,gpu=true
+func LoadNodeSelectorWhitelist(file string) (map[string]map[string]string, error) {
+	if file == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", file)
+	}
+	namespaces := map[string]string{}
+	if err := yaml.Unmarshal(data, &namespaces); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", file)
+	}
+	whitelist := map[string]map[string]string{}
+	for namespace, value := range namespaces {
+		set, err := labels.ConvertSelectorToLabelsMap(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid node selector whitelist of namespace %s", namespace)
+		}
+		whitelist[namespace] = set
+	}
+	return whitelist, nil
+}
@@ -0,0 +1,100 @@
+package pod
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const nodeSelectorAnnotation = "scheduler.alpha.kubernetes.io/node-selector"
+
+func newNodeSelectorNamespace(name, selector string) corev1.Namespace {
+	return corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{nodeSelectorAnnotation: selector}}}
+}
+
+func TestUpdateNodeSelector(t *testing.T) {
+	handler := &podHandler{Log: logf.Log, PodMutaterConfig: platformv1.PodMutaterConfig{NodeSelectorAnnotation: nodeSelectorAnnotation}}
+	namespace := newNodeSelectorNamespace("team-a", "node.kubernetes.io/group=team-a,zone in (a,b),!spot")
+	pod := &corev1.Pod{Spec: corev1.PodSpec{
+		NodeSelector: map[string]string{"disk": "ssd"},
+		Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{
+				{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "arch", Operator: corev1.NodeSelectorOpIn, Values: []string{"amd64"}}}},
+				{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "arch", Operator: corev1.NodeSelectorOpIn, Values: []string{"arm64"}}}},
+			},
+		}}},
+	}}
+
+	pod, err := handler.UpdateNodeSelector(namespace, pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pod.Spec.NodeSelector, map[string]string{"disk": "ssd", "node.kubernetes.io/group": "team-a"}) {
+		t.Errorf("expected the node selectors to be merged, got %v", pod.Spec.NodeSelector)
+	}
+	expected := []corev1.NodeSelectorRequirement{
+		{Key: "spot", Operator: corev1.NodeSelectorOpDoesNotExist},
+		{Key: "zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"a", "b"}},
+	}
+	for _, term := range pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		if len(term.MatchExpressions) != 3 || !reflect.DeepEqual(term.MatchExpressions[1:], expected) {
+			t.Errorf("expected the set based requirements to be added to every term, got %v", term.MatchExpressions)
+		}
+	}
+
+	empty, err := handler.UpdateNodeSelector(newNodeSelectorNamespace("team-a", "zone notin (c)"), &corev1.Pod{})
+	if err != nil || len(empty.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms) != 1 {
+		t.Errorf("expected a required node affinity term to be created, got %v %v", empty.Spec.Affinity, err)
+	}
+}
+
+func TestUpdateNodeSelectorConflicts(t *testing.T) {
+	handler := &podHandler{Log: logf.Log, PodMutaterConfig: platformv1.PodMutaterConfig{
+		NodeSelectorAnnotation: nodeSelectorAnnotation,
+		NodeSelectorWhitelist:  map[string]map[string]string{"team-a": {"node.kubernetes.io/group": "team-a", "disk": "ssd"}},
+	}}
+	fixtures := []struct {
+		namespace    corev1.Namespace
+		nodeSelector map[string]string
+		allowed      bool
+	}{
+		{newNodeSelectorNamespace("team-b", "group=team-b"), map[string]string{"group": "team-a"}, false},
+		{newNodeSelectorNamespace("team-b", "zone in (a,b)"), map[string]string{"zone": "c"}, false},
+		{newNodeSelectorNamespace("team-b", "!spot"), map[string]string{"spot": "true"}, false},
+		{newNodeSelectorNamespace("team-b", "group=team-b"), map[string]string{"group": "team-b"}, true},
+		{newNodeSelectorNamespace("team-a", "node.kubernetes.io/group=team-a"), map[string]string{"disk": "ssd"}, true},
+		{newNodeSelectorNamespace("team-a", "node.kubernetes.io/group=team-a"), map[string]string{"disk": "hdd"}, false},
+		{newNodeSelectorNamespace("team-a", "node.kubernetes.io/group=team-b"), nil, false},
+		{corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}, map[string]string{"gpu": "true"}, false},
+		{newNodeSelectorNamespace("team-b", "in valid"), nil, false},
+	}
+	for _, fixture := range fixtures {
+		pod := &corev1.Pod{Spec: corev1.PodSpec{NodeSelector: fixture.nodeSelector}}
+		if _, err := handler.UpdateNodeSelector(fixture.namespace, pod); (err == nil) != fixture.allowed {
+			t.Errorf("expected %v in %s to be allowed=%v, got %v", fixture.nodeSelector, fixture.namespace.Name, fixture.allowed, err)
+		}
+	}
+}
+
+func TestLoadNodeSelectorWhitelist(t *testing.T) {
+	file, err := ioutil.TempFile("", "whitelist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString("team-a: node.kubernetes.io/group=team-a,gpu=true\n"); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	whitelist, err := LoadNodeSelectorWhitelist(file.Name())
+	if err != nil || !reflect.DeepEqual(whitelist["team-a"], map[string]string{"node.kubernetes.io/group": "team-a", "gpu": "true"}) {
+		t.Errorf("unexpected whitelist %v %v", whitelist, err)
+	}
+}
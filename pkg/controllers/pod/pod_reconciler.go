@@ -18,14 +18,18 @@ package pod
 
 import (
 	"context"
+	"sort"
 	"strings"
 
 	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	"github.com/flanksource/platform-operator/pkg/controllers/inheritance"
+	perrors "github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -34,21 +38,27 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
+// InheritedAnnotationsAnnotation is set on pods to the comma separated keys of the annotations inherited from the
+// namespace
+const InheritedAnnotationsAnnotation = "platform.flanksource.com/inherited-annotations"
+
 type PodReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
-	Config platformv1.PodMutaterConfig
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	Config   platformv1.PodMutaterConfig
+	// hierarchy merges the annotations of the ancestors of namespaces
+	hierarchy *NamespaceHierarchy
 }
 
-func NewPodReconciler(mgr manager.Manager, cfg platformv1.PodMutaterConfig) reconcile.Reconciler {
-	cfg.AnnotationsMap = make(map[string]bool)
-	for _, a := range cfg.Annotations {
-		cfg.AnnotationsMap[a] = true
-	}
+func NewPodReconciler(mgr manager.Manager, cfg platformv1.PodMutaterConfig, hierarchy *NamespaceHierarchy) reconcile.Reconciler {
+	cfg.AnnotationsMap = annotationsMap(cfg.Annotations)
 	return &PodReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-		Config: cfg,
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor(name),
+		Config:    cfg,
+		hierarchy: hierarchy,
 	}
 }
 
@@ -64,6 +74,7 @@ func addPodReconciler(mgr manager.Manager, r reconcile.Reconciler) error {
 // +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;create;update
 // +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;update;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *PodReconciler) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
 	pod := corev1.Pod{}
@@ -80,8 +91,13 @@ func (r *PodReconciler) Reconcile(ctx context.Context, request reconcile.Request
 		log.Error(err, "Namespace not found", "namespace", pod.Namespace, "pod", pod.Name)
 		return reconcile.Result{Requeue: true}, err
 	}
+	namespace = r.hierarchy.Inherit(ctx, r.Recorder, namespace)
 
-	podsChanged := RequiresAnnotationUpdate(namespace, r.Config, pod)
+	cfg, err := resolveConfig(ctx, r.Client, r.Config, namespace, &pod)
+	if err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+	podsChanged := RequiresAnnotationUpdate(ctx, namespace, cfg, r.Recorder, pod)
 	if len(podsChanged) == 0 {
 		log.V(2).Info("Nothing to update", "namespace", pod.Namespace, "pod", pod.Name)
 	}
@@ -97,17 +113,27 @@ func (r *PodReconciler) Reconcile(ctx context.Context, request reconcile.Request
 	return reconcile.Result{}, nil
 }
 
-func RequiresAnnotationUpdate(ns v1.Namespace, cfg platformv1.PodMutaterConfig, pods ...v1.Pod) []v1.Pod {
+// RequiresAnnotationUpdate returns the pods whose inherited annotations or labels changed, errors rendering annotations
+// are recorded as events on the namespace
+func RequiresAnnotationUpdate(ctx context.Context, ns v1.Namespace, cfg platformv1.PodMutaterConfig, recorder record.EventRecorder, pods ...v1.Pod) []v1.Pod {
 	changedPods := []v1.Pod{}
 	for _, pod := range pods {
-		if updated, changed := UpdateAnnotations(ns, cfg, &pod); changed {
+		updated, labelsChanged := UpdateLabels(ns, cfg, &pod)
+		updated, annotationsChanged, errs := UpdateAnnotations(ctx, ns, cfg, updated)
+		recordAnnotationErrors(recorder, ns, updated, errs)
+		if annotationsChanged || labelsChanged {
 			changedPods = append(changedPods, *updated)
 		}
 	}
 	return changedPods
 }
 
-func UpdateAnnotations(ns v1.Namespace, cfg platformv1.PodMutaterConfig, pod *v1.Pod) (*v1.Pod, bool) {
+// UpdateAnnotations inherits the whitelisted annotations of the namespace. The inherited keys are tracked in the
+// InheritedAnnotationsAnnotation so that they follow changes to the namespace: inherited values are updated and removed
+// with the namespace annotation or its whitelisting, while annotations set by the pod author are never changed.
+// Namespace annotations can be templates rendered per pod, annotations failing to render are not inherited or updated
+// and the errors are returned.
+func UpdateAnnotations(ctx context.Context, ns v1.Namespace, cfg platformv1.PodMutaterConfig, pod *v1.Pod) (*v1.Pod, bool, []error) {
 	if ns.Annotations == nil {
 		ns.Annotations = map[string]string{}
 	}
@@ -117,6 +143,33 @@ func UpdateAnnotations(ns v1.Namespace, cfg platformv1.PodMutaterConfig, pod *v1
 	}
 
 	changed := false
+	errs := []error{}
+	// templates are rendered against the pod as it is before inheriting anything
+	original := pod.DeepCopy()
+	render := func(k, v string) (string, bool) {
+		rendered, err := renderAnnotation(ctx, v, ns, original)
+		if err != nil {
+			errs = append(errs, perrors.Wrapf(err, "failed to render annotation %s", k))
+			return "", false
+		}
+		return rendered, true
+	}
+
+	inherited := inheritedAnnotations(pod)
+	for k := range inherited {
+		v, exists := ns.Annotations[k]
+		if !exists || !isWhitelisted(k, cfg) {
+			delete(pod.Annotations, k)
+			delete(inherited, k)
+			changed = true
+		} else if rendered, ok := render(k, v); !ok {
+			continue
+		} else if current, ok := pod.Annotations[k]; !ok || current != rendered {
+			pod.Annotations[k] = rendered
+			changed = true
+		}
+	}
+
 	for k, v := range ns.Annotations {
 		if !isWhitelisted(k, cfg) {
 			continue
@@ -126,10 +179,65 @@ func UpdateAnnotations(ns v1.Namespace, cfg platformv1.PodMutaterConfig, pod *v1
 			// if pod already has annotation, don't inherit
 			continue
 		}
-		pod.Annotations[k] = v
+		rendered, ok := render(k, v)
+		if !ok {
+			continue
+		}
+		pod.Annotations[k] = rendered
+		inherited[k] = true
 		changed = true
 	}
 
+	setInheritedAnnotations(pod, inherited)
+	return pod, changed, errs
+}
+
+// releaseChangedAnnotations stops tracking the inherited annotations changed by a pod update, as the pod author then
+// owns them and they must not be reverted to the namespace value
+func releaseChangedAnnotations(ctx context.Context, ns v1.Namespace, old, pod *v1.Pod) {
+	inherited := inheritedAnnotations(pod)
+	for k := range inherited {
+		v, exists := pod.Annotations[k]
+		if !exists || v == old.Annotations[k] {
+			continue
+		}
+		if rendered, err := renderAnnotation(ctx, ns.Annotations[k], ns, pod); err != nil || v != rendered {
+			delete(inherited, k)
+		}
+	}
+	setInheritedAnnotations(pod, inherited)
+}
+
+func inheritedAnnotations(pod *v1.Pod) map[string]bool {
+	inherited := map[string]bool{}
+	for _, k := range strings.Split(pod.Annotations[InheritedAnnotationsAnnotation], ",") {
+		if k != "" {
+			inherited[k] = true
+		}
+	}
+	return inherited
+}
+
+func setInheritedAnnotations(pod *v1.Pod, inherited map[string]bool) {
+	if len(inherited) == 0 {
+		delete(pod.Annotations, InheritedAnnotationsAnnotation)
+		return
+	}
+	keys := []string{}
+	for k := range inherited {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[InheritedAnnotationsAnnotation] = strings.Join(keys, ",")
+}
+
+// UpdateLabels adds the namespace labels matching the Labels prefixes to the pod, unless the pod already sets them
+func UpdateLabels(ns v1.Namespace, cfg platformv1.PodMutaterConfig, pod *v1.Pod) (*v1.Pod, bool) {
+	labels, changed := inheritance.InheritLabels(cfg.Labels, ns.Labels, pod.Labels)
+	pod.Labels = labels
 	return pod, changed
 }
 
@@ -0,0 +1,207 @@
+package pod
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestRequiresAnnotationUpdate(t *testing.T) {
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{"co.elastic.logs/enabled": "true"},
+		Labels:      map[string]string{"team": "payments", "environment": "prod"},
+	}}
+	cfg := platformv1.PodMutaterConfig{AnnotationsMap: annotationsMap([]string{"co.elastic"}), Labels: []string{"team"}}
+	pods := []corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "unlabelled"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "labelled", Labels: map[string]string{"team": "platform"}, Annotations: map[string]string{"co.elastic.logs/enabled": "false"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "annotated", Annotations: map[string]string{"co.elastic.logs/enabled": "false"}}},
+	}
+
+	changed := RequiresAnnotationUpdate(context.Background(), ns, cfg, nil, pods...)
+	if len(changed) != 2 || changed[0].Name != "unlabelled" || changed[1].Name != "annotated" {
+		t.Fatalf("expected the unlabelled and annotated pods to change, got %v", changed)
+	}
+	if labels := changed[0].Labels; len(labels) != 1 || labels["team"] != "payments" {
+		t.Errorf("expected only the whitelisted labels to be inherited, got %v", labels)
+	}
+	if changed[0].Annotations["co.elastic.logs/enabled"] != "true" || changed[1].Labels["team"] != "payments" {
+		t.Errorf("expected the annotations and labels to be inherited, got %v", changed)
+	}
+}
+
+func TestUpdateAnnotationsTracksInherited(t *testing.T) {
+	cfg := platformv1.PodMutaterConfig{AnnotationsMap: annotationsMap([]string{"co.elastic"})}
+	namespace := func(annotations map[string]string) corev1.Namespace {
+		return corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}
+	}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"co.elastic.logs/multiline": "author"}}}
+
+	pod, _, _ = UpdateAnnotations(context.Background(), namespace(map[string]string{
+		"co.elastic.logs/enabled":   "true",
+		"co.elastic.logs/module":    "nginx",
+		"co.elastic.logs/multiline": "namespace",
+	}), cfg, pod)
+	if pod.Annotations[InheritedAnnotationsAnnotation] != "co.elastic.logs/enabled,co.elastic.logs/module" {
+		t.Errorf("expected the inherited annotations to be tracked, got %v", pod.Annotations)
+	}
+
+	pod, changed, _ := UpdateAnnotations(context.Background(), namespace(map[string]string{
+		"co.elastic.logs/enabled": "false",
+		"co.elastic.logs/json":    "true",
+	}), cfg, pod)
+	expected := map[string]string{
+		"co.elastic.logs/enabled":      "false",
+		"co.elastic.logs/json":         "true",
+		"co.elastic.logs/multiline":    "author",
+		InheritedAnnotationsAnnotation: "co.elastic.logs/enabled,co.elastic.logs/json",
+	}
+	if !changed || !reflect.DeepEqual(pod.Annotations, expected) {
+		t.Errorf("expected inherited annotations to be updated and removed, got %v", pod.Annotations)
+	}
+
+	pod, changed, _ = UpdateAnnotations(context.Background(), namespace(nil), platformv1.PodMutaterConfig{}, pod)
+	if !changed || !reflect.DeepEqual(pod.Annotations, map[string]string{"co.elastic.logs/multiline": "author"}) {
+		t.Errorf("expected only the annotations of the author to be kept, got %v", pod.Annotations)
+	}
+}
+
+func TestUpdateExistingPodReleasesChangedAnnotations(t *testing.T) {
+	handler := &podHandler{PodMutaterConfig: platformv1.PodMutaterConfig{AnnotationsMap: annotationsMap([]string{"co.elastic"})}}
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"co.elastic.logs/enabled": "true", "co.elastic.logs/module": "nginx"}}}
+	old := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		"co.elastic.logs/enabled":      "true",
+		"co.elastic.logs/module":       "nginx",
+		InheritedAnnotationsAnnotation: "co.elastic.logs/enabled,co.elastic.logs/module",
+	}}}
+	pod := old.DeepCopy()
+	pod.Annotations["co.elastic.logs/enabled"] = "false"
+
+	pod = handler.UpdateExistingPod(context.Background(), ns, old, pod)
+	if pod.Annotations["co.elastic.logs/enabled"] != "false" || pod.Annotations[InheritedAnnotationsAnnotation] != "co.elastic.logs/module" {
+		t.Errorf("expected the annotation changed by the update to be released, got %v", pod.Annotations)
+	}
+}
+
+func TestUpdateAnnotationsTemplates(t *testing.T) {
+	cfg := platformv1.PodMutaterConfig{AnnotationsMap: annotationsMap([]string{"co.elastic", "team"})}
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "payments",
+		Labels: map[string]string{"team": "core"},
+		Annotations: map[string]string{
+			"co.elastic.logs/index": `{{ index .Namespace.Labels "team" }}-{{ .Namespace.Name }}-{{ .Pod.Labels.app }}`,
+			"co.elastic.logs/owner": `{{ .Pod.Labels.owner }}`,
+			"team/invalid":          `{{ .Pod.Spec }}`,
+			"team/unterminated":     `{{ .Namespace.Name`,
+			"team/unbounded":        `{{ range .Namespace.Labels }}{{ printf "%070000d" 0 }}{{ end }}`,
+		},
+	}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "api"}}}
+	recorder := record.NewFakeRecorder(10)
+
+	changed := RequiresAnnotationUpdate(context.Background(), ns, cfg, recorder, *pod)
+	if len(changed) != 1 {
+		t.Fatalf("expected the pod to change, got %v", changed)
+	}
+	annotations := changed[0].Annotations
+	if annotations["co.elastic.logs/index"] != "core-payments-api" || annotations["co.elastic.logs/owner"] != "" {
+		t.Errorf("expected the templates to be rendered for the pod, got %v", annotations)
+	}
+	if annotations[InheritedAnnotationsAnnotation] != "co.elastic.logs/index,co.elastic.logs/owner" {
+		t.Errorf("expected only the rendered annotations to be inherited, got %v", annotations)
+	}
+	if len(recorder.Events) != 3 {
+		t.Fatalf("expected an event for each annotation failing to render, got %d", len(recorder.Events))
+	}
+	for i := 0; i < 3; i++ {
+		if event := <-recorder.Events; !strings.Contains(event, "InvalidAnnotationTemplate") {
+			t.Errorf("expected an InvalidAnnotationTemplate event, got %s", event)
+		}
+	}
+
+	// the tracked value follows the labels of the pod
+	pod = &changed[0]
+	pod.Labels["app"] = "worker"
+	if changed := RequiresAnnotationUpdate(context.Background(), ns, cfg, nil, *pod); len(changed) != 1 || changed[0].Annotations["co.elastic.logs/index"] != "core-payments-worker" {
+		t.Errorf("expected the rendered annotation to be updated, got %v", changed)
+	}
+}
+
+func TestRenderAnnotationRestrictions(t *testing.T) {
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments", Labels: map[string]string{"team": "core"}}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "api"}}}
+
+	for _, value := range []string{
+		`{{ range 1000000000 }}x{{ end }}`,
+		`{{ $n := 10 }}{{ range $n }}x{{ end }}`,
+		`{{ range len .Pod.Labels }}x{{ end }}`,
+		`{{ call .Pod.Labels }}`,
+		`{{ printf "%d" 1 }}`,
+		`{{ define "loop" }}{{ template "loop" }}{{ end }}{{ template "loop" }}`,
+	} {
+		if _, err := renderAnnotation(context.Background(), value, ns, pod); err == nil {
+			t.Errorf("expected %s to be rejected", value)
+		}
+	}
+
+	value := `{{ range $k, $v := .Pod.Labels }}{{ if eq $k "app" }}{{ $v }}-{{ index $.Namespace.Labels "team" }}{{ end }}{{ end }}`
+	if rendered, err := renderAnnotation(context.Background(), value, ns, pod); err != nil || rendered != "api-core" {
+		t.Errorf("expected ranging over labels to be allowed, got %s %v", rendered, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := renderAnnotation(ctx, `{{ .Namespace.Name }}`, ns, pod); err == nil {
+		t.Error("expected rendering to fail once the admission request is done")
+	}
+}
+
+func TestAnnotationTemplatesRenderAsAdmitted(t *testing.T) {
+	handler := &podHandler{Recorder: record.NewFakeRecorder(10), PodMutaterConfig: platformv1.PodMutaterConfig{
+		AnnotationsMap: annotationsMap([]string{"co.elastic", "team"}),
+		Labels:         []string{"team"},
+	}}
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "payments",
+		Labels: map[string]string{"team": "core"},
+		Annotations: map[string]string{
+			"co.elastic.logs/index": `{{ .Pod.GenerateName }}{{ .Pod.Labels.team }}-{{ .Pod.Labels.app }}`,
+			"co.elastic.logs/keys":  `{{ range $k, $v := .Pod.Annotations }}{{ $k }},{{ end }}`,
+			"team/name":             `{{ .Pod.Name }}`,
+		},
+	}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		GenerateName: "api-",
+		Labels:       map[string]string{"app": "api"},
+		Annotations:  map[string]string{"owner": "alice"},
+	}}
+
+	admitted, err := handler.UpdatePod(context.Background(), ns, pod.DeepCopy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if admitted.Annotations["co.elastic.logs/index"] != "api-core-api" || admitted.Annotations["co.elastic.logs/keys"] != "owner," {
+		t.Errorf("expected the templates to be rendered with the inherited labels and the pod annotations, got %v", admitted.Annotations)
+	}
+	if _, ok := admitted.Annotations["team/name"]; ok {
+		t.Errorf("expected the name of the pod not to be available to templates, got %v", admitted.Annotations)
+	}
+
+	// the reconciler renders the same values once the pod is created with a name
+	created := admitted.DeepCopy()
+	created.Name = "api-x7k2p"
+	created.Namespace = ns.Name
+	recorder := record.NewFakeRecorder(10)
+	if changed := RequiresAnnotationUpdate(context.Background(), ns, handler.PodMutaterConfig, recorder, *created); len(changed) != 0 {
+		t.Errorf("expected the reconciler not to change the admitted annotations, got %v", changed[0].Annotations)
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("expected the name template to fail in the reconciler too, got %d events", len(recorder.Events))
+	}
+}
@@ -0,0 +1,139 @@
+package pod
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:rbac:groups=platform.flanksource.com,resources=podmutationpolicies,verbs=get;list;watch
+
+// resolveConfig returns the config for a pod, i.e. cfg overridden by the PodMutationPolicies selecting the pod
+func resolveConfig(ctx context.Context, c client.Client, cfg platformv1.PodMutaterConfig, namespace corev1.Namespace, pod *corev1.Pod) (platformv1.PodMutaterConfig, error) {
+	policies := platformv1.PodMutationPolicyList{}
+	if err := c.List(ctx, &policies); err != nil {
+		return cfg, errors.Wrap(err, "failed to list pod mutation policies")
+	}
+	return applyNamespaceOverrides(applyPolicies(cfg, policies.Items, namespace, pod), namespace), nil
+}
+
+// applyNamespaceOverrides applies the registry prefix, image pull secrets, resource defaults and environment variables
+// annotations of the namespace, which take precedence over both the flags and policies
+func applyNamespaceOverrides(cfg platformv1.PodMutaterConfig, namespace corev1.Namespace) platformv1.PodMutaterConfig {
+	if prefix := namespace.Annotations[RegistryPrefixAnnotation]; prefix != "" {
+		cfg.DefaultRegistryPrefix = prefix
+	}
+	if secrets := namespace.Annotations[ImagePullSecretsAnnotation]; secrets != "" {
+		cfg.ImagePullSecrets = nil
+		for _, secret := range strings.Split(secrets, ",") {
+			if secret = strings.TrimSpace(secret); secret != "" {
+				cfg.ImagePullSecrets = append(cfg.ImagePullSecrets, secret)
+			}
+		}
+	}
+	for annotation, resources := range map[string]*corev1.ResourceList{
+		DefaultRequestsAnnotation:      &cfg.DefaultRequests,
+		DefaultLimitsAnnotation:        &cfg.DefaultLimits,
+		MaxLimitRequestRatioAnnotation: &cfg.MaxLimitRequestRatio,
+	} {
+		value := namespace.Annotations[annotation]
+		if value == "" {
+			continue
+		}
+		overrides, err := ParseResourceList(value)
+		if err != nil {
+			log.Error(err, "Ignoring invalid annotation", "namespace", namespace.Name, "annotation", annotation)
+			continue
+		}
+		*resources = mergeResources(*resources, overrides)
+	}
+	if value := namespace.Annotations[EnvAnnotation]; value != "" {
+		if overrides, err := ParseEnv(value); err != nil {
+			log.Error(err, "Ignoring invalid annotation", "namespace", namespace.Name, "annotation", EnvAnnotation)
+		} else {
+			cfg.Env = mergeEnv(cfg.Env, overrides)
+		}
+	}
+	return cfg
+}
+
+// applyPolicies applies the policies selecting the pod on top of cfg in priority order, settings of higher priority
+// policies override those of lower priority policies and of cfg, while the annotations, whitelists and mirrors are merged.
+// Resource defaults are overridden per resource.
+func applyPolicies(cfg platformv1.PodMutaterConfig, policies []platformv1.PodMutationPolicy, namespace corev1.Namespace, pod *corev1.Pod) platformv1.PodMutaterConfig {
+	matching := []platformv1.PodMutationPolicy{}
+	for _, policy := range policies {
+		if selects(policy, namespace, pod) {
+			matching = append(matching, policy)
+		}
+	}
+	// apply from lowest to highest priority, with ties applied in reverse name order so the first name wins
+	sort.Slice(matching, func(i, j int) bool {
+		if matching[i].Spec.Priority != matching[j].Spec.Priority {
+			return matching[i].Spec.Priority < matching[j].Spec.Priority
+		}
+		return matching[i].Name > matching[j].Name
+	})
+
+	cfg.Annotations = append([]string{}, cfg.Annotations...)
+	cfg.RegistryWhitelist = append([]string{}, cfg.RegistryWhitelist...)
+	mirrors := map[string]string{}
+	for registry, mirror := range cfg.RegistryMirrors {
+		mirrors[registry] = mirror
+	}
+	for _, policy := range matching {
+		spec := policy.Spec
+		cfg.Annotations = append(cfg.Annotations, spec.Annotations...)
+		cfg.RegistryWhitelist = append(cfg.RegistryWhitelist, spec.RegistryWhitelist...)
+		for registry, mirror := range spec.RegistryMirrors {
+			mirrors[registry] = mirror
+		}
+		if spec.DefaultRegistryPrefix != "" {
+			cfg.DefaultRegistryPrefix = spec.DefaultRegistryPrefix
+		}
+		if spec.DefaultImagePullSecret != "" {
+			cfg.DefaultImagePullSecret = spec.DefaultImagePullSecret
+		}
+		if spec.TolerationsAnnotation != "" {
+			cfg.TolerationsAnnotation = spec.TolerationsAnnotation
+		}
+		cfg.DefaultRequests = mergeResources(cfg.DefaultRequests, spec.DefaultRequests)
+		cfg.DefaultLimits = mergeResources(cfg.DefaultLimits, spec.DefaultLimits)
+		cfg.MaxLimitRequestRatio = mergeResources(cfg.MaxLimitRequestRatio, spec.MaxLimitRequestRatio)
+	}
+	cfg.RegistryMirrors = mirrors
+	cfg.AnnotationsMap = annotationsMap(cfg.Annotations)
+	return cfg
+}
+
+func selects(policy platformv1.PodMutationPolicy, namespace corev1.Namespace, pod *corev1.Pod) bool {
+	return selectorMatches(policy.Name, policy.Spec.NamespaceSelector, namespace.Labels) && selectorMatches(policy.Name, policy.Spec.PodSelector, pod.Labels)
+}
+
+// selectorMatches returns true if the selector of the named policy is not set or matches the labels
+func selectorMatches(policy string, selector *metav1.LabelSelector, set map[string]string) bool {
+	if selector == nil {
+		return true
+	}
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		log.Error(err, "Ignoring policy with an invalid selector", "policy", policy)
+		return false
+	}
+	return s.Matches(labels.Set(set))
+}
+
+func annotationsMap(annotations []string) map[string]bool {
+	m := make(map[string]bool)
+	for _, a := range annotations {
+		m[a] = true
+	}
+	return m
+}
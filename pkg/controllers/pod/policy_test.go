@@ -0,0 +1,84 @@
+package pod
+
+import (
+	"reflect"
+	"testing"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newPolicy(name string, priority int32, namespaceLabels map[string]string, spec platformv1.PodMutationPolicySpec) platformv1.PodMutationPolicy {
+	spec.Priority = priority
+	if namespaceLabels != nil {
+		spec.NamespaceSelector = &metav1.LabelSelector{MatchLabels: namespaceLabels}
+	}
+	return platformv1.PodMutationPolicy{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: spec}
+}
+
+func TestApplyPolicies(t *testing.T) {
+	cfg := platformv1.PodMutaterConfig{
+		DefaultRegistryPrefix: "registry.corp",
+		RegistryWhitelist:     []string{"registry.corp"},
+		TolerationsAnnotation: "tolerations",
+	}
+	policies := []platformv1.PodMutationPolicy{
+		newPolicy("team-a", 10, map[string]string{"tenant": "a"}, platformv1.PodMutationPolicySpec{
+			DefaultRegistryPrefix: "registry.team-a",
+			RegistryWhitelist:     []string{"registry.team-a"},
+		}),
+		newPolicy("team-a-override", 20, map[string]string{"tenant": "a"}, platformv1.PodMutationPolicySpec{
+			DefaultRegistryPrefix:  "registry.team-a-mirror",
+			DefaultImagePullSecret: "team-a",
+		}),
+		newPolicy("team-b", 100, map[string]string{"tenant": "b"}, platformv1.PodMutationPolicySpec{
+			DefaultRegistryPrefix: "registry.team-b",
+		}),
+		newPolicy("gpu", 0, nil, platformv1.PodMutationPolicySpec{
+			PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"gpu": "true"}},
+			Annotations: []string{"gpu.corp"},
+		}),
+	}
+	namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "a", Labels: map[string]string{"tenant": "a"}}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"gpu": "true"}}}
+
+	resolved := applyPolicies(cfg, policies, namespace, pod)
+	if resolved.DefaultRegistryPrefix != "registry.team-a-mirror" || resolved.DefaultImagePullSecret != "team-a" {
+		t.Errorf("expected the highest priority policy to win, got %+v", resolved)
+	}
+	if !reflect.DeepEqual(resolved.RegistryWhitelist, []string{"registry.corp", "registry.team-a"}) {
+		t.Errorf("expected whitelists to be merged, got %v", resolved.RegistryWhitelist)
+	}
+	if !resolved.AnnotationsMap["gpu.corp"] {
+		t.Errorf("expected the pod selector policy to apply, got %v", resolved.AnnotationsMap)
+	}
+	if resolved.TolerationsAnnotation != "tolerations" || len(cfg.RegistryWhitelist) != 1 {
+		t.Errorf("expected the defaults to be kept and not modified, got %+v", resolved)
+	}
+
+	other := applyPolicies(cfg, policies, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "c"}}, &corev1.Pod{})
+	if other.DefaultRegistryPrefix != "registry.corp" || len(other.AnnotationsMap) != 0 {
+		t.Errorf("expected the defaults for an unselected namespace, got %+v", other)
+	}
+}
+
+func TestApplyNamespaceOverrides(t *testing.T) {
+	cfg := platformv1.PodMutaterConfig{DefaultRegistryPrefix: "registry.corp", DefaultImagePullSecret: "default"}
+	namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Annotations: map[string]string{
+		RegistryPrefixAnnotation:   "registry.corp/team-a",
+		ImagePullSecretsAnnotation: "team-a, shared,",
+	}}}
+
+	resolved := applyNamespaceOverrides(cfg, namespace)
+	if resolved.DefaultRegistryPrefix != "registry.corp/team-a" {
+		t.Errorf("expected the namespace registry prefix, got %s", resolved.DefaultRegistryPrefix)
+	}
+	if !reflect.DeepEqual(resolved.ImagePullSecrets, []string{"team-a", "shared"}) {
+		t.Errorf("expected the namespace pull secrets, got %v", resolved.ImagePullSecrets)
+	}
+
+	if resolved := applyNamespaceOverrides(cfg, corev1.Namespace{}); !reflect.DeepEqual(resolved, cfg) {
+		t.Errorf("expected no overrides without annotations, got %+v", resolved)
+	}
+}
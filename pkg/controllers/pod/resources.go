@@ -0,0 +1,168 @@
+package pod
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/inf.v0"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// DefaultRequestsAnnotation on a namespace is a comma separated list of resource=quantity requests of containers
+	// not setting them, e.g. cpu=100m,memory=128Mi
+	DefaultRequestsAnnotation = "platform.flanksource.com/default-requests"
+	// DefaultLimitsAnnotation on a namespace is a comma separated list of resource=quantity limits of containers not
+	// setting them
+	DefaultLimitsAnnotation = "platform.flanksource.com/default-limits"
+	// MaxLimitRequestRatioAnnotation on a namespace is a comma separated list of resource=ratio maximum ratios of the
+	// limit to the request of containers, e.g. cpu=4,memory=2
+	MaxLimitRequestRatioAnnotation = "platform.flanksource.com/max-limit-request-ratio"
+)
+
+// UpdateResources fills in the requests and limits containers do not set from DefaultRequests and DefaultLimits.
+// Requests are only defaulted when the container sets neither a request nor a limit for the resource, as the API server
+// defaults the request to the limit. Defaulted limits are lowered to MaxLimitRequestRatio times the request, while an
+// error is returned for containers setting both a request and a limit exceeding it.
+func (handler *podHandler) UpdateResources(pod *corev1.Pod) (*corev1.Pod, error) {
+	if len(handler.DefaultRequests) == 0 && len(handler.DefaultLimits) == 0 && len(handler.MaxLimitRequestRatio) == 0 {
+		return pod, nil
+	}
+	defaulted := map[string]corev1.ResourceRequirements{}
+	update := func(containers []corev1.Container) error {
+		for i := range containers {
+			container := &containers[i]
+			requirements, err := handler.defaultResources(container)
+			if err != nil {
+				return errors.Wrapf(err, "container %s", container.Name)
+			}
+			if len(requirements.Requests) > 0 || len(requirements.Limits) > 0 {
+				defaulted[container.Name] = requirements
+			}
+		}
+		return nil
+	}
+	if err := update(pod.Spec.InitContainers); err != nil {
+		return pod, err
+	}
+	if err := update(pod.Spec.Containers); err != nil {
+		return pod, err
+	}
+	if len(defaulted) == 0 {
+		return pod, nil
+	}
+
+	return pod, recordDefaulted(pod, "resources", defaulted)
+}
+
+// defaultResources defaults the resources of the container and returns the defaulted requests and limits
+func (handler *podHandler) defaultResources(container *corev1.Container) (corev1.ResourceRequirements, error) {
+	defaulted := corev1.ResourceRequirements{Requests: corev1.ResourceList{}, Limits: corev1.ResourceList{}}
+	resources := &container.Resources
+
+	for name, request := range handler.DefaultRequests {
+		if _, ok := resources.Requests[name]; ok {
+			continue
+		}
+		if _, ok := resources.Limits[name]; ok {
+			continue
+		}
+		if resources.Requests == nil {
+			resources.Requests = corev1.ResourceList{}
+		}
+		resources.Requests[name] = request.DeepCopy()
+		defaulted.Requests[name] = request.DeepCopy()
+	}
+
+	for name, limit := range handler.DefaultLimits {
+		if _, ok := resources.Limits[name]; ok {
+			continue
+		}
+		limit = limit.DeepCopy()
+		if request, ok := resources.Requests[name]; ok && request.Cmp(limit) > 0 {
+			// a limit below the request is invalid
+			limit = request.DeepCopy()
+		}
+		if resources.Limits == nil {
+			resources.Limits = corev1.ResourceList{}
+		}
+		resources.Limits[name] = limit
+		defaulted.Limits[name] = limit
+	}
+
+	for name, ratio := range handler.MaxLimitRequestRatio {
+		request, hasRequest := resources.Requests[name]
+		limit, hasLimit := resources.Limits[name]
+		if !hasRequest || !hasLimit || request.IsZero() {
+			continue
+		}
+		max := maxLimit(request, ratio)
+		if limit.Cmp(max) <= 0 {
+			continue
+		}
+		_, limitDefaulted := defaulted.Limits[name]
+		_, requestDefaulted := defaulted.Requests[name]
+		if !limitDefaulted && !requestDefaulted {
+			return defaulted, errors.Errorf("%s limit %s exceeds %s times the request %s", name, limit.String(), ratio.String(), request.String())
+		}
+		if !limitDefaulted {
+			// requests are only defaulted without a limit, so this cannot happen
+			continue
+		}
+		resources.Limits[name] = max
+		defaulted.Limits[name] = max.DeepCopy()
+	}
+
+	if len(defaulted.Requests) == 0 {
+		defaulted.Requests = nil
+	}
+	if len(defaulted.Limits) == 0 {
+		defaulted.Limits = nil
+	}
+	return defaulted, nil
+}
+
+// maxLimit returns ratio times the request, rounded down to a milli unit
+func maxLimit(request, ratio resource.Quantity) resource.Quantity {
+	max := new(inf.Dec).Mul(request.AsDec(), ratio.AsDec())
+	result := resource.Quantity{Format: request.Format}
+	result.AsDec().Round(max, 3, inf.RoundDown)
+	return result
+}
+
+// ParseResourceList parses a comma separated list of resource=quantity pairs, e.g. cpu=100m,memory=128Mi
+func ParseResourceList(value string) (corev1.ResourceList, error) {
+	resources := corev1.ResourceList{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("invalid resource %s, expected resource=quantity", pair)
+		}
+		quantity, err := resource.ParseQuantity(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid quantity of %s", parts[0])
+		}
+		resources[corev1.ResourceName(parts[0])] = quantity
+	}
+	return resources, nil
+}
+
+// mergeResources returns a copy of resources with the quantities of overrides
+func mergeResources(resources, overrides corev1.ResourceList) corev1.ResourceList {
+	if len(overrides) == 0 {
+		return resources
+	}
+	merged := resources.DeepCopy()
+	if merged == nil {
+		merged = corev1.ResourceList{}
+	}
+	for name, quantity := range overrides {
+		merged[name] = quantity.DeepCopy()
+	}
+	return merged
+}
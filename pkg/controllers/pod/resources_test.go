@@ -0,0 +1,146 @@
+package pod
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func mustParseResourceList(t *testing.T, value string) corev1.ResourceList {
+	resources, err := ParseResourceList(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resources
+}
+
+func TestUpdateResources(t *testing.T) {
+	handler := &podHandler{Log: logf.Log, PodMutaterConfig: platformv1.PodMutaterConfig{
+		DefaultRequests:      mustParseResourceList(t, "cpu=100m,memory=128Mi"),
+		DefaultLimits:        mustParseResourceList(t, "cpu=1,memory=512Mi"),
+		MaxLimitRequestRatio: mustParseResourceList(t, "cpu=4,memory=2"),
+	}}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "empty"},
+		{Name: "limited", Resources: corev1.ResourceRequirements{Limits: mustParseResourceList(t, "cpu=200m")}},
+		{Name: "big", Resources: corev1.ResourceRequirements{Requests: mustParseResourceList(t, "memory=1Gi")}},
+		{Name: "set", Resources: corev1.ResourceRequirements{
+			Requests: mustParseResourceList(t, "cpu=250m,memory=256Mi"),
+			Limits:   mustParseResourceList(t, "cpu=1,memory=512Mi"),
+		}},
+	}}}
+
+	pod, err := handler.UpdateResources(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"empty":   "limits: cpu=400m memory=256Mi requests: cpu=100m memory=128Mi",
+		"limited": "limits: cpu=200m memory=256Mi requests: memory=128Mi",
+		"big":     "limits: cpu=400m memory=1Gi requests: cpu=100m memory=1Gi",
+		"set":     "limits: cpu=1 memory=512Mi requests: cpu=250m memory=256Mi",
+	}
+	for _, container := range pod.Spec.Containers {
+		if actual := formatRequirements(container.Resources); actual != expected[container.Name] {
+			t.Errorf("expected %s to have %s, got %s", container.Name, expected[container.Name], actual)
+		}
+	}
+
+	defaulted := map[string]corev1.ResourceRequirements{}
+	if err := json.Unmarshal([]byte(defaultedFields(t, pod)["resources"]), &defaulted); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := defaulted["set"]; ok || len(defaulted) != 3 {
+		t.Errorf("expected the defaulted resources of 3 containers to be recorded, got %v", defaulted)
+	}
+	if actual := formatRequirements(defaulted["limited"]); actual != "limits: memory=256Mi requests: memory=128Mi" {
+		t.Errorf("expected only the defaulted resources to be recorded, got %s", actual)
+	}
+}
+
+func TestUpdateResourcesRatio(t *testing.T) {
+	handler := &podHandler{Log: logf.Log, PodMutaterConfig: platformv1.PodMutaterConfig{MaxLimitRequestRatio: mustParseResourceList(t, "cpu=2")}}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Resources: corev1.ResourceRequirements{
+		Requests: mustParseResourceList(t, "cpu=100m"),
+		Limits:   mustParseResourceList(t, "cpu=1"),
+	}}}}}
+	if _, err := handler.UpdateResources(pod); err == nil || !strings.Contains(err.Error(), "container app: cpu limit 1 exceeds 2 times the request 100m") {
+		t.Errorf("expected a ratio violation, got %v", err)
+	}
+}
+
+func TestApplyNamespaceResourceOverrides(t *testing.T) {
+	cfg := platformv1.PodMutaterConfig{DefaultRequests: mustParseResourceList(t, "cpu=100m,memory=128Mi")}
+	namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		DefaultRequestsAnnotation: "memory=1Gi",
+		DefaultLimitsAnnotation:   "invalid",
+	}}}
+	resolved := applyNamespaceOverrides(cfg, namespace)
+	if actual := formatRequirements(corev1.ResourceRequirements{Requests: resolved.DefaultRequests}); actual != "requests: cpu=100m memory=1Gi" {
+		t.Errorf("expected the namespace requests to override per resource, got %s", actual)
+	}
+	if len(resolved.DefaultLimits) != 0 || cfg.DefaultRequests.Memory().String() != "128Mi" {
+		t.Errorf("expected invalid annotations to be ignored and the defaults not to be modified, got %+v", resolved)
+	}
+}
+
+func formatRequirements(requirements corev1.ResourceRequirements) string {
+	format := func(resources corev1.ResourceList) string {
+		s := ""
+		for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			if quantity, ok := resources[name]; ok {
+				s += " " + string(name) + "=" + quantity.String()
+			}
+		}
+		return s
+	}
+	s := ""
+	if len(requirements.Limits) > 0 {
+		s += "limits:" + format(requirements.Limits)
+	}
+	if len(requirements.Requests) > 0 {
+		s += " requests:" + format(requirements.Requests)
+	}
+	return strings.TrimSpace(s)
+}
+
+func TestUpdateResourcesRatioLargeQuantities(t *testing.T) {
+	handler := &podHandler{Log: logf.Log, PodMutaterConfig: platformv1.PodMutaterConfig{
+		DefaultLimits:        mustParseResourceList(t, "memory=64Ei"),
+		MaxLimitRequestRatio: mustParseResourceList(t, "memory=1500m"),
+	}}
+	// milli values of these quantities overflow int64
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "defaulted", Resources: corev1.ResourceRequirements{Requests: mustParseResourceList(t, "memory=2Ti")}},
+		{Name: "within", Resources: corev1.ResourceRequirements{
+			Requests: mustParseResourceList(t, "memory=2Ti"),
+			Limits:   mustParseResourceList(t, "memory=3Ti"),
+		}},
+	}}}
+	pod, err := handler.UpdateResources(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"defaulted": "limits: memory=3Ti requests: memory=2Ti",
+		"within":    "limits: memory=3Ti requests: memory=2Ti",
+	}
+	for _, container := range pod.Spec.Containers {
+		if actual := formatRequirements(container.Resources); actual != expected[container.Name] {
+			t.Errorf("expected %s to have %s, got %s", container.Name, expected[container.Name], actual)
+		}
+	}
+
+	pod = &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Resources: corev1.ResourceRequirements{
+		Requests: mustParseResourceList(t, "memory=2Ti"),
+		Limits:   mustParseResourceList(t, "memory=4Ti"),
+	}}}}}
+	if _, err := handler.UpdateResources(pod); err == nil || !strings.Contains(err.Error(), "memory limit 4Ti exceeds 1500m times the request 2Ti") {
+		t.Errorf("expected a ratio violation, got %v", err)
+	}
+}
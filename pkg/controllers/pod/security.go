@@ -0,0 +1,125 @@
+package pod
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// SecurityProfileLabel on a namespace selects the security context defaults of its pods, restricted or baseline
+	SecurityProfileLabel = "platform.flanksource.com/security-profile"
+
+	// RestrictedSecurityProfile defaults pods to the restricted Pod Security Standard
+	RestrictedSecurityProfile = "restricted"
+	// BaselineSecurityProfile only defaults the seccomp profile, which the baseline Pod Security Standard does not
+	// require but which is the one default that does not break images running as root
+	BaselineSecurityProfile = "baseline"
+	// PrivilegedSecurityProfile does not default anything, the same as not setting a profile
+	PrivilegedSecurityProfile = "privileged"
+
+	seccompPodAnnotation = "seccomp.security.alpha.kubernetes.io/pod"
+)
+
+// UpdateSecurityContext fills in the security context fields of the pod and its containers not set explicitly, according
+// to the security profile of the namespace:
+//
+//	baseline    seccompProfile RuntimeDefault
+//	restricted  seccompProfile RuntimeDefault, runAsNonRoot, allowPrivilegeEscalation=false and dropping ALL capabilities
+//
+// Fields are not defaulted when that would make the pod invalid, e.g. runAsNonRoot for pods running as user 0 or
+// allowPrivilegeEscalation=false for privileged containers, these are left to admission to reject.
+func (handler *podHandler) UpdateSecurityContext(namespace corev1.Namespace, pod *corev1.Pod) *corev1.Pod {
+	profile := namespace.Labels[SecurityProfileLabel]
+	if profile == "" || profile == PrivilegedSecurityProfile {
+		return pod
+	}
+	if profile != RestrictedSecurityProfile && profile != BaselineSecurityProfile {
+		handler.Log.Info("Ignoring unknown security profile", "namespace", namespace.Name, "profile", profile)
+		return pod
+	}
+	restricted := profile == RestrictedSecurityProfile
+
+	defaulted := []string{}
+	podContext := pod.Spec.SecurityContext
+	if podContext == nil {
+		podContext = &corev1.PodSecurityContext{}
+	}
+	if podContext.SeccompProfile == nil && pod.Annotations[seccompPodAnnotation] == "" {
+		podContext.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+		defaulted = append(defaulted, "spec.securityContext.seccompProfile")
+	}
+	if restricted && podContext.RunAsNonRoot == nil && !runsAsRoot(pod) {
+		runAsNonRoot := true
+		podContext.RunAsNonRoot = &runAsNonRoot
+		defaulted = append(defaulted, "spec.securityContext.runAsNonRoot")
+	}
+	if len(defaulted) > 0 {
+		pod.Spec.SecurityContext = podContext
+	}
+
+	if restricted {
+		update := func(field string, containers []corev1.Container) {
+			for i := range containers {
+				for _, name := range defaultContainerSecurityContext(&containers[i]) {
+					defaulted = append(defaulted, fmt.Sprintf("spec.%s[%s].securityContext.%s", field, containers[i].Name, name))
+				}
+			}
+		}
+		update("initContainers", pod.Spec.InitContainers)
+		update("containers", pod.Spec.Containers)
+	}
+	if len(defaulted) == 0 {
+		return pod
+	}
+
+	if err := recordDefaulted(pod, "securityContext", defaulted); err != nil {
+		handler.Log.Error(err, "Failed to record defaulted security context", "namespace", namespace.Name, "pod", pod.Name)
+	}
+	return pod
+}
+
+// defaultContainerSecurityContext applies the restricted defaults to the container and returns the fields defaulted
+func defaultContainerSecurityContext(container *corev1.Container) []string {
+	defaulted := []string{}
+	if container.SecurityContext == nil {
+		container.SecurityContext = &corev1.SecurityContext{}
+	}
+	sc := container.SecurityContext
+	if sc.Capabilities == nil {
+		sc.Capabilities = &corev1.Capabilities{}
+	}
+
+	// the API server rejects allowPrivilegeEscalation=false for privileged containers or containers adding CAP_SYS_ADMIN
+	privileged := sc.Privileged != nil && *sc.Privileged
+	for _, capability := range sc.Capabilities.Add {
+		if capability == "SYS_ADMIN" || capability == "CAP_SYS_ADMIN" {
+			privileged = true
+		}
+	}
+	if sc.AllowPrivilegeEscalation == nil && !privileged {
+		allowPrivilegeEscalation := false
+		sc.AllowPrivilegeEscalation = &allowPrivilegeEscalation
+		defaulted = append(defaulted, "allowPrivilegeEscalation")
+	}
+	if len(sc.Capabilities.Drop) == 0 {
+		sc.Capabilities.Drop = []corev1.Capability{"ALL"}
+		defaulted = append(defaulted, "capabilities.drop")
+	}
+	return defaulted
+}
+
+// runsAsRoot returns true if the pod or any of its containers explicitly runs as user 0
+func runsAsRoot(pod *corev1.Pod) bool {
+	if sc := pod.Spec.SecurityContext; sc != nil && sc.RunAsUser != nil && *sc.RunAsUser == 0 {
+		return true
+	}
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range containers {
+			if sc := container.SecurityContext; sc != nil && sc.RunAsUser != nil && *sc.RunAsUser == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package pod
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestUpdateSecurityContext(t *testing.T) {
+	handler := &podHandler{Log: logf.Log}
+	namespace := func(profile string) corev1.Namespace {
+		return corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{SecurityProfileLabel: profile}}}
+	}
+	yes, no := true, false
+	root := int64(0)
+
+	pod := &corev1.Pod{Spec: corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "init"}},
+		Containers: []corev1.Container{
+			{Name: "app"},
+			{Name: "explicit", SecurityContext: &corev1.SecurityContext{
+				AllowPrivilegeEscalation: &yes,
+				Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"NET_RAW"}},
+			}},
+			{Name: "privileged", SecurityContext: &corev1.SecurityContext{Privileged: &yes}},
+		},
+	}}
+	pod = handler.UpdateSecurityContext(namespace(RestrictedSecurityProfile), pod)
+
+	podContext := pod.Spec.SecurityContext
+	if podContext.SeccompProfile.Type != corev1.SeccompProfileTypeRuntimeDefault || !*podContext.RunAsNonRoot {
+		t.Errorf("expected the pod security context to be defaulted, got %v", podContext)
+	}
+	for _, container := range []corev1.Container{pod.Spec.InitContainers[0], pod.Spec.Containers[0]} {
+		sc := container.SecurityContext
+		if *sc.AllowPrivilegeEscalation || !reflect.DeepEqual(sc.Capabilities.Drop, []corev1.Capability{"ALL"}) {
+			t.Errorf("expected the security context of %s to be defaulted, got %v", container.Name, sc)
+		}
+	}
+	if sc := pod.Spec.Containers[1].SecurityContext; !*sc.AllowPrivilegeEscalation || sc.Capabilities.Drop[0] != "NET_RAW" {
+		t.Errorf("expected explicit values to be kept, got %v", sc)
+	}
+	if sc := pod.Spec.Containers[2].SecurityContext; sc.AllowPrivilegeEscalation != nil {
+		t.Errorf("expected allowPrivilegeEscalation not to be defaulted for privileged containers, got %v", sc)
+	}
+	expected := `["spec.securityContext.seccompProfile","spec.securityContext.runAsNonRoot",` +
+		`"spec.initContainers[init].securityContext.allowPrivilegeEscalation","spec.initContainers[init].securityContext.capabilities.drop",` +
+		`"spec.containers[app].securityContext.allowPrivilegeEscalation","spec.containers[app].securityContext.capabilities.drop",` +
+		`"spec.containers[privileged].securityContext.capabilities.drop"]`
+	if value := defaultedFields(t, pod)["securityContext"]; value != expected {
+		t.Errorf("expected the defaulted fields %s, got %s", expected, value)
+	}
+
+	rootPod := &corev1.Pod{Spec: corev1.PodSpec{
+		SecurityContext: &corev1.PodSecurityContext{RunAsNonRoot: &no},
+		Containers:      []corev1.Container{{Name: "app", SecurityContext: &corev1.SecurityContext{RunAsUser: &root}}},
+	}}
+	rootPod = handler.UpdateSecurityContext(namespace(RestrictedSecurityProfile), rootPod)
+	if *rootPod.Spec.SecurityContext.RunAsNonRoot {
+		t.Error("expected an explicit runAsNonRoot to be kept")
+	}
+	rootPod.Spec.SecurityContext.RunAsNonRoot = nil
+	rootPod = handler.UpdateSecurityContext(namespace(RestrictedSecurityProfile), rootPod)
+	if rootPod.Spec.SecurityContext.RunAsNonRoot != nil {
+		t.Error("expected runAsNonRoot not to be defaulted for pods running as root")
+	}
+
+	annotated := handler.UpdateSecurityContext(namespace(RestrictedSecurityProfile), &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{seccompPodAnnotation: "runtime/default"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", SecurityContext: &corev1.SecurityContext{RunAsUser: &root}}}},
+	})
+	if annotated.Spec.SecurityContext != nil {
+		t.Errorf("expected the pod security context not to be allocated without defaulting a field, got %v", annotated.Spec.SecurityContext)
+	}
+
+	baseline := handler.UpdateSecurityContext(namespace(BaselineSecurityProfile), &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}})
+	if baseline.Spec.SecurityContext.SeccompProfile == nil || baseline.Spec.SecurityContext.RunAsNonRoot != nil || baseline.Spec.Containers[0].SecurityContext != nil {
+		t.Errorf("expected only the seccomp profile to be defaulted, got %v", baseline.Spec)
+	}
+
+	for _, profile := range []string{"", "privileged"} {
+		unchanged := handler.UpdateSecurityContext(namespace(profile), &corev1.Pod{})
+		if unchanged.Spec.SecurityContext != nil || unchanged.Annotations != nil {
+			t.Errorf("expected pods in namespaces with the %q profile not to be changed, got %v", profile, unchanged)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package pod
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"text/template"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// InjectSidecarsAnnotation on a pod or namespace is a comma separated list of the sidecar templates injected into
+	// the pod, or all pods of the namespace
+	InjectSidecarsAnnotation = "platform.flanksource.com/inject-sidecars"
+	// InjectedSidecarsAnnotation is set on pods to the sidecar templates injected into them
+	InjectedSidecarsAnnotation = "platform.flanksource.com/injected-sidecars"
+	// SidecarTemplateKey is the key of a ConfigMap sidecar template holding the SidecarTemplateSpec as YAML
+	SidecarTemplateKey = "sidecar.yaml"
+
+	containerRestartPolicyAlways = "Always"
+)
+
+// sidecarTemplateData is available to the templated fields of sidecar templates
+type sidecarTemplateData struct {
+	Pod       *corev1.Pod
+	Namespace corev1.Namespace
+}
+
+// +kubebuilder:rbac:groups=platform.flanksource.com,resources=sidecartemplates,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+
+// InjectSidecars injects the sidecar templates the pod or its namespace opt in to. The native sidecars of all templates
+// come first in the init containers, followed by the init containers of the templates and then those of the pod, so
+// that sidecars are running before any other init container starts. Templates already injected, and containers or
+// volumes the pod already has, are skipped so that injecting is idempotent. The names of the injected native sidecars
+// are returned, as their restartPolicy cannot be represented by corev1.Container.
+func (handler *podHandler) InjectSidecars(ctx context.Context, namespace corev1.Namespace, pod *corev1.Pod) ([]string, error) {
+	injected := splitList(pod.Annotations[InjectedSidecarsAnnotation])
+	names := []string{}
+	for _, name := range append(splitList(namespace.Annotations[InjectSidecarsAnnotation]), splitList(pod.Annotations[InjectSidecarsAnnotation])...) {
+		if !contains(injected, name) && !contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	existing := map[string]bool{}
+	for _, container := range append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		existing[container.Name] = true
+	}
+	add := func(containers []corev1.Container) []corev1.Container {
+		added := []corev1.Container{}
+		for _, container := range containers {
+			if !existing[container.Name] {
+				existing[container.Name] = true
+				added = append(added, container)
+			}
+		}
+		return added
+	}
+
+	native, initContainers := []corev1.Container{}, []corev1.Container{}
+	for _, name := range names {
+		spec, err := handler.getSidecarTemplate(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if err := renderSidecarTemplate(spec, sidecarTemplateData{Pod: pod, Namespace: namespace}); err != nil {
+			return nil, errors.Wrapf(err, "failed to render sidecar template %s", name)
+		}
+		handler.Log.Info("Injecting sidecar template", "pod", pod.GetName(), "template", name)
+		native = append(native, add(spec.NativeSidecars)...)
+		initContainers = append(initContainers, add(spec.InitContainers)...)
+		pod.Spec.Containers = append(pod.Spec.Containers, add(spec.Containers)...)
+		for _, volume := range spec.Volumes {
+			if !hasVolume(pod, volume.Name) {
+				pod.Spec.Volumes = append(pod.Spec.Volumes, volume)
+			}
+		}
+		injected = append(injected, name)
+	}
+	pod.Spec.InitContainers = append(append(native, initContainers...), pod.Spec.InitContainers...)
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[InjectedSidecarsAnnotation] = strings.Join(injected, ",")
+
+	nativeNames := []string{}
+	for _, container := range native {
+		nativeNames = append(nativeNames, container.Name)
+	}
+	return nativeNames, nil
+}
+
+// getSidecarTemplate returns the spec of the SidecarTemplate with the name, or of the ConfigMap with the name in the
+// SidecarTemplatesNamespace when there is no such SidecarTemplate
+func (handler *podHandler) getSidecarTemplate(ctx context.Context, name string) (*platformv1.SidecarTemplateSpec, error) {
+	template := platformv1.SidecarTemplate{}
+	err := handler.Client.Get(ctx, types.NamespacedName{Name: name}, &template)
+	if err == nil {
+		return &template.Spec, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, errors.Wrapf(err, "failed to get sidecar template %s", name)
+	}
+	if handler.SidecarTemplatesNamespace == "" {
+		return nil, errors.Errorf("sidecar template %s not found", name)
+	}
+
+	cm := corev1.ConfigMap{}
+	if err := handler.Client.Get(ctx, types.NamespacedName{Namespace: handler.SidecarTemplatesNamespace, Name: name}, &cm); apierrors.IsNotFound(err) {
+		return nil, errors.Errorf("sidecar template %s not found", name)
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to get sidecar template %s", name)
+	}
+	spec := platformv1.SidecarTemplateSpec{}
+	if err := yaml.Unmarshal([]byte(cm.Data[SidecarTemplateKey]), &spec); err != nil {
+		return nil, errors.Wrapf(err, "invalid sidecar template %s/%s", cm.Namespace, cm.Name)
+	}
+	return &spec, nil
+}
+
+// renderSidecarTemplate renders every string of the spec that contains a template
+func renderSidecarTemplate(spec *platformv1.SidecarTemplateSpec, data sidecarTemplateData) error {
+	raw, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	var obj interface{}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return err
+	}
+	if obj, err = renderStrings(obj, data); err != nil {
+		return err
+	}
+	if raw, err = json.Marshal(obj); err != nil {
+		return err
+	}
+	*spec = platformv1.SidecarTemplateSpec{}
+	return json.Unmarshal(raw, spec)
+}
+
+func renderStrings(obj interface{}, data sidecarTemplateData) (interface{}, error) {
+	switch v := obj.(type) {
+	case string:
+		if !strings.Contains(v, "{{") {
+			return v, nil
+		}
+		tpl, err := template.New("").Option("missingkey=zero").Parse(v)
+		if err != nil {
+			return nil, err
+		}
+		out := bytes.Buffer{}
+		if err := tpl.Execute(&out, data); err != nil {
+			return nil, err
+		}
+		return out.String(), nil
+	case []interface{}:
+		for i := range v {
+			rendered, err := renderStrings(v[i], data)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = rendered
+		}
+	case map[string]interface{}:
+		for key := range v {
+			rendered, err := renderStrings(v[key], data)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = rendered
+		}
+	}
+	return obj, nil
+}
+
+// setRestartPolicies sets the restartPolicy of init containers in the marshaled pod, which is dropped when the pod is
+// decoded into corev1.Pod: native sidecars that were injected get Always, while init containers that already had a
+// restartPolicy in the original pod keep it
+func setRestartPolicies(original, marshaled []byte, native []string) ([]byte, error) {
+	pod := struct {
+		Spec struct {
+			InitContainers []struct {
+				Name          string `json:"name"`
+				RestartPolicy string `json:"restartPolicy,omitempty"`
+			} `json:"initContainers"`
+		} `json:"spec"`
+	}{}
+	if err := json.Unmarshal(original, &pod); err != nil {
+		return nil, err
+	}
+	policies := map[string]string{}
+	for _, container := range pod.Spec.InitContainers {
+		if container.RestartPolicy != "" {
+			policies[container.Name] = container.RestartPolicy
+		}
+	}
+	for _, name := range native {
+		policies[name] = containerRestartPolicyAlways
+	}
+	if len(policies) == 0 {
+		return marshaled, nil
+	}
+
+	obj := map[string]interface{}{}
+	if err := json.Unmarshal(marshaled, &obj); err != nil {
+		return nil, err
+	}
+	spec, _ := obj["spec"].(map[string]interface{})
+	initContainers, _ := spec["initContainers"].([]interface{})
+	for _, container := range initContainers {
+		container, ok := container.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if policy, ok := policies[container["name"].(string)]; ok {
+			container["restartPolicy"] = policy
+		}
+	}
+	return json.Marshal(obj)
+}
+
+func hasVolume(pod *corev1.Pod, name string) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
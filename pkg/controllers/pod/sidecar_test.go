@@ -0,0 +1,138 @@
+package pod
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func newSidecarHandler(t *testing.T, objects ...client.Object) *podHandler {
+	s := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := platformv1.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	return &podHandler{
+		Client:           fake.NewClientBuilder().WithScheme(s).WithObjects(objects...).Build(),
+		Log:              logf.Log,
+		PodMutaterConfig: platformv1.PodMutaterConfig{SidecarTemplatesNamespace: "platform-system"},
+	}
+}
+
+func TestInjectSidecars(t *testing.T) {
+	logShipper := &platformv1.SidecarTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "log-shipper"},
+		Spec: platformv1.SidecarTemplateSpec{
+			NativeSidecars: []corev1.Container{{
+				Name:  "fluent-bit",
+				Image: "fluent/fluent-bit:1.7",
+				Env:   []corev1.EnvVar{{Name: "TAG", Value: `{{ .Namespace.Name }}.{{ index .Pod.Labels "app" }}`}},
+			}},
+			Volumes: []corev1.Volume{{Name: "logs"}, {Name: "data"}},
+		},
+	}
+	authProxy := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "auth-proxy", Namespace: "platform-system"},
+		Data: map[string]string{SidecarTemplateKey: `
+initContainers:
+- name: auth-config
+  image: busybox
+containers:
+- name: oauth2-proxy
+  image: quay.io/oauth2-proxy/oauth2-proxy:v7.0.0
+`},
+	}
+	handler := newSidecarHandler(t, logShipper, authProxy)
+	namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Annotations: map[string]string{InjectSidecarsAnnotation: "log-shipper"}}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      map[string]string{"app": "web"},
+			Annotations: map[string]string{InjectSidecarsAnnotation: "auth-proxy, log-shipper"},
+		},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "migrate"}},
+			Containers:     []corev1.Container{{Name: "web"}},
+			Volumes:        []corev1.Volume{{Name: "data"}},
+		},
+	}
+
+	native, err := handler.InjectSidecars(context.Background(), namespace, pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(native) != 1 || native[0] != "fluent-bit" {
+		t.Errorf("expected fluent-bit to be a native sidecar, got %v", native)
+	}
+	if names := containerNames(pod.Spec.InitContainers); names != "fluent-bit,auth-config,migrate" {
+		t.Errorf("expected native sidecars first, then template init containers, got %s", names)
+	}
+	if names := containerNames(pod.Spec.Containers); names != "web,oauth2-proxy" {
+		t.Errorf("expected the sidecar to be appended, got %s", names)
+	}
+	if len(pod.Spec.Volumes) != 2 {
+		t.Errorf("expected existing volumes not to be duplicated, got %v", pod.Spec.Volumes)
+	}
+	if tag := pod.Spec.InitContainers[0].Env[0].Value; tag != "team-a.web" {
+		t.Errorf("expected the template to be rendered, got %s", tag)
+	}
+	if pod.Annotations[InjectedSidecarsAnnotation] != "log-shipper,auth-proxy" {
+		t.Errorf("expected the injected templates to be recorded, got %s", pod.Annotations[InjectedSidecarsAnnotation])
+	}
+
+	if native, err = handler.InjectSidecars(context.Background(), namespace, pod); err != nil || len(native) != 0 {
+		t.Fatal(native, err)
+	}
+	if len(pod.Spec.InitContainers) != 3 || len(pod.Spec.Containers) != 2 {
+		t.Errorf("expected injecting to be idempotent, got %s %s", containerNames(pod.Spec.InitContainers), containerNames(pod.Spec.Containers))
+	}
+
+	pod.Annotations[InjectSidecarsAnnotation] = "missing"
+	if _, err := handler.InjectSidecars(context.Background(), corev1.Namespace{}, pod); err == nil {
+		t.Error("expected a missing template to fail")
+	}
+}
+
+func TestSetRestartPolicies(t *testing.T) {
+	original := []byte(`{"spec":{"initContainers":[{"name":"istio","restartPolicy":"Always"},{"name":"migrate"}]}}`)
+	marshaled := []byte(`{"spec":{"initContainers":[{"name":"fluent-bit"},{"name":"istio"},{"name":"migrate"}]}}`)
+
+	patched, err := setRestartPolicies(original, marshaled, []string{"fluent-bit"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := struct {
+		Spec struct {
+			InitContainers []map[string]string `json:"initContainers"`
+		} `json:"spec"`
+	}{}
+	if err := json.Unmarshal(patched, &pod); err != nil {
+		t.Fatal(err)
+	}
+	for i, policy := range []string{"Always", "Always", ""} {
+		if pod.Spec.InitContainers[i]["restartPolicy"] != policy {
+			t.Errorf("expected %s to have restartPolicy %q, got %v", pod.Spec.InitContainers[i]["name"], policy, pod.Spec.InitContainers[i])
+		}
+	}
+}
+
+func containerNames(containers []corev1.Container) string {
+	names := ""
+	for i, container := range containers {
+		if i > 0 {
+			names += ","
+		}
+		names += container.Name
+	}
+	return names
+}
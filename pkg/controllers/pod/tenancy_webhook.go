@@ -0,0 +1,243 @@
+package pod
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// tenancyHandler enforces the isolation of node groups dedicated to namespaces with the tolerations annotation: pods
+// in such a namespace must tolerate and select its node group, and pods of other namespaces must not tolerate it
+type tenancyHandler struct {
+	Client client.Client
+	*admission.Decoder
+	Log logr.Logger
+	platformv1.TenancyConfig
+}
+
+//+kubebuilder:webhook:path=/validate-v1-pod-tenancy,mutating=false,sideEffects=None,admissionReviewVersions=v1,failurePolicy=fail,groups="",resources=pods,verbs=create;update,versions=v1,name=validate-pod-tenancy.platform.flanksource.com
+func NewTenancyValidatingWebhook(client client.Client, cfg platformv1.TenancyConfig) *admission.Webhook {
+	decoder, _ := admission.NewDecoder(client.Scheme())
+	return &admission.Webhook{
+		Handler: &tenancyHandler{
+			Client:        client,
+			Decoder:       decoder,
+			TenancyConfig: cfg,
+			Log:           logf.Log.WithName("pod-tenancy")},
+	}
+}
+
+var _ admission.Handler = &tenancyHandler{}
+
+func (handler *tenancyHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if !handler.Enabled || req.SubResource != "" || handler.isExempt(req.Namespace) {
+		return admission.Allowed("")
+	}
+	pod := &corev1.Pod{}
+	if err := handler.Decode(req, pod); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if req.Operation == admissionv1.Update {
+		// the node selector cannot be changed after creation, so only updates adding tolerations need validating
+		old := &corev1.Pod{}
+		if err := handler.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		if reflect.DeepEqual(old.Spec.Tolerations, pod.Spec.Tolerations) {
+			return admission.Allowed("")
+		}
+	}
+
+	// namespaces are listed from the cache of the manager's client, once per request
+	namespaces := corev1.NamespaceList{}
+	if err := handler.Client.List(ctx, &namespaces); err != nil {
+		return admission.Errored(http.StatusInternalServerError, errors.Wrap(err, "failed to list namespaces"))
+	}
+	violations := handler.validate(req.Namespace, namespaces.Items, pod)
+	if len(violations) == 0 {
+		return admission.Allowed("")
+	}
+	return admission.Denied(fmt.Sprintf("pod %s/%s violates node group tenancy: %s", req.Namespace, podName(req, pod), strings.Join(violations, "; ")))
+}
+
+// inheritedGroup returns the tolerations of the node group of the namespace merged with the annotations of its ancestors
+func (handler *tenancyHandler) inheritedGroup(namespace string, byName map[string]*corev1.Namespace) []corev1.Toleration {
+	ns, ok := byName[namespace]
+	if !ok {
+		return nil
+	}
+	ancestors, err := walkAncestors(*ns, handler.ParentLabel, func(name string) (*corev1.Namespace, error) {
+		return byName[name], nil
+	})
+	if err != nil {
+		// cycles are reported as events on the namespace by the mutating webhook
+		ancestors = nil
+	}
+	inheritable := func(key string) bool { return key == handler.TolerationsAnnotation }
+	tolerations, _ := ParseTolerations(mergeAncestors(*ns, ancestors, inheritable).Annotations[handler.TolerationsAnnotation])
+	return handler.nodeGroups(tolerations)
+}
+
+// wellKnownTaintPrefixes are taints set by Kubernetes itself, e.g. node.kubernetes.io/unreachable, which the
+// DefaultTolerationSeconds admission plugin tolerates on every pod, so they never dedicate a node group
+var wellKnownTaintPrefixes = []string{"node.kubernetes.io/", "node-role.kubernetes.io/"}
+
+// nodeGroups returns the tolerations of a namespace dedicating node groups, i.e. those of the NodeGroupTaints which
+// are not well-known taints. Other tolerations are only added to pods and not validated.
+func (handler *tenancyHandler) nodeGroups(tolerations []corev1.Toleration) []corev1.Toleration {
+	groups := []corev1.Toleration{}
+	for _, toleration := range tolerations {
+		if toleration.Key == "" || hasKeyPrefix(toleration.Key, wellKnownTaintPrefixes) {
+			continue
+		}
+		if hasKeyPrefix(toleration.Key, handler.NodeGroupTaints) {
+			groups = append(groups, toleration)
+		}
+	}
+	return groups
+}
+
+// hasKeyPrefix returns true if the key is one of the keys or starts with one of the prefixes ending with /
+func hasKeyPrefix(key string, keys []string) bool {
+	for _, k := range keys {
+		if k != "" && (key == k || (strings.HasSuffix(k, "/") && strings.HasPrefix(key, k))) {
+			return true
+		}
+	}
+	return false
+}
+
+func (handler *tenancyHandler) isExempt(namespace string) bool {
+	for _, exempt := range handler.ExemptNamespaces {
+		if exempt == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// validate returns the violations of the pod in the namespace given the node groups of all namespaces
+func (handler *tenancyHandler) validate(namespace string, namespaces []corev1.Namespace, pod *corev1.Pod) []string {
+	groups := map[string][]corev1.Toleration{}
+	byName := map[string]*corev1.Namespace{}
+	for i := range namespaces {
+		ns := &namespaces[i]
+		byName[ns.Name] = ns
+		value := ns.Annotations[handler.TolerationsAnnotation]
+		if value == "" {
+			continue
+		}
+		// invalid entries are reported as events on the namespace by the mutating webhook
+		tolerations, _ := ParseTolerations(value)
+		if tolerations = handler.nodeGroups(tolerations); len(tolerations) > 0 {
+			groups[ns.Name] = tolerations
+		}
+	}
+
+	violations := []string{}
+	own := groups[namespace]
+	if handler.ParentLabel != "" {
+		// sub-namespaces are bound to the node group they inherit from their nearest ancestor
+		own = handler.inheritedGroup(namespace, byName)
+	}
+	for _, toleration := range own {
+		if toleration.Key == "" {
+			continue
+		}
+		for _, taint := range groupTaints(toleration) {
+			if !tolerates(pod.Spec.Tolerations, taint) {
+				violations = append(violations, fmt.Sprintf("missing toleration %s of the node group of namespace %s", formatToleration(toleration), namespace))
+				break
+			}
+		}
+		if value, ok := pod.Spec.NodeSelector[toleration.Key]; !ok {
+			violations = append(violations, fmt.Sprintf("missing node selector %s of the node group of namespace %s", formatSelector(toleration), namespace))
+		} else if toleration.Operator != corev1.TolerationOpExists && value != toleration.Value {
+			violations = append(violations, fmt.Sprintf("node selector %s=%s does not match %s of the node group of namespace %s", toleration.Key, value, formatSelector(toleration), namespace))
+		}
+	}
+
+	others := []string{}
+	for name := range groups {
+		if name != namespace {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	for _, other := range others {
+		for _, toleration := range groups[other] {
+			if hasToleration(own, toleration) {
+				// node groups shared with the namespace of the pod
+				continue
+			}
+			for _, taint := range groupTaints(toleration) {
+				if tolerates(pod.Spec.Tolerations, taint) {
+					violations = append(violations, fmt.Sprintf("toleration of %s is reserved for the node group of namespace %s", taint.ToString(), other))
+					break
+				}
+			}
+		}
+	}
+	return violations
+}
+
+// groupTaints returns the taints of a node group from the toleration of its namespace
+func groupTaints(toleration corev1.Toleration) []corev1.Taint {
+	if toleration.Key == "" {
+		return nil
+	}
+	if toleration.Effect != "" {
+		return []corev1.Taint{{Key: toleration.Key, Value: toleration.Value, Effect: toleration.Effect}}
+	}
+	taints := []corev1.Taint{}
+	for _, effect := range []corev1.TaintEffect{corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute} {
+		taints = append(taints, corev1.Taint{Key: toleration.Key, Value: toleration.Value, Effect: effect})
+	}
+	return taints
+}
+
+func tolerates(tolerations []corev1.Toleration, taint corev1.Taint) bool {
+	for _, toleration := range tolerations {
+		if toleration.ToleratesTaint(&taint) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatToleration(toleration corev1.Toleration) string {
+	s := formatSelector(toleration)
+	if toleration.Effect != "" {
+		s += ":" + string(toleration.Effect)
+	}
+	if toleration.TolerationSeconds != nil {
+		s += fmt.Sprintf("=%d", *toleration.TolerationSeconds)
+	}
+	return s
+}
+
+func formatSelector(toleration corev1.Toleration) string {
+	if toleration.Operator == corev1.TolerationOpExists {
+		return toleration.Key
+	}
+	return toleration.Key + "=" + toleration.Value
+}
+
+func podName(req admission.Request, pod *corev1.Pod) string {
+	if req.Name != "" {
+		return req.Name
+	}
+	return pod.GenerateName
+}
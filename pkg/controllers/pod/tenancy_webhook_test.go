@@ -0,0 +1,115 @@
+package pod
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var tenancyConfig = platformv1.TenancyConfig{
+	Enabled:               true,
+	TolerationsAnnotation: "tolerations",
+	NodeGroupTaints:       []string{"platform.flanksource.com/", "dedicated"},
+}
+
+func TestValidateTenancy(t *testing.T) {
+	handler := &tenancyHandler{TenancyConfig: tenancyConfig}
+	namespaces := []corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Annotations: map[string]string{"tolerations": "platform.flanksource.com/node-group=a"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-b", Annotations: map[string]string{"tolerations": "platform.flanksource.com/node-group=b:NoExecute"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "shared"}},
+	}
+	groupA := corev1.Toleration{Key: "platform.flanksource.com/node-group", Operator: corev1.TolerationOpEqual, Value: "a", Effect: corev1.TaintEffectNoSchedule}
+	groupB := corev1.Toleration{Key: "platform.flanksource.com/node-group", Operator: corev1.TolerationOpEqual, Value: "b", Effect: corev1.TaintEffectNoExecute}
+	newPod := func(nodeSelector map[string]string, tolerations ...corev1.Toleration) *corev1.Pod {
+		return &corev1.Pod{Spec: corev1.PodSpec{NodeSelector: nodeSelector, Tolerations: tolerations}}
+	}
+
+	fixtures := []struct {
+		name       string
+		namespace  string
+		pod        *corev1.Pod
+		violations []string
+	}{
+		{"bound", "team-a", newPod(map[string]string{"platform.flanksource.com/node-group": "a"}, groupA), nil},
+		{"missing toleration", "team-a", newPod(map[string]string{"platform.flanksource.com/node-group": "a"}), []string{"missing toleration platform.flanksource.com/node-group=a:NoSchedule"}},
+		{"missing node selector", "team-a", newPod(nil, groupA), []string{"missing node selector platform.flanksource.com/node-group=a"}},
+		{"other node selector", "team-a", newPod(map[string]string{"platform.flanksource.com/node-group": "b"}, groupA), []string{"node selector platform.flanksource.com/node-group=b does not match"}},
+		{"other group", "team-a", newPod(map[string]string{"platform.flanksource.com/node-group": "a"}, groupA, groupB), []string{"reserved for the node group of namespace team-b"}},
+		{"unbound", "shared", newPod(nil), nil},
+		{"unbound tolerating a group", "shared", newPod(nil, groupB), []string{"platform.flanksource.com/node-group=b:NoExecute is reserved for the node group of namespace team-b"}},
+		{"unbound tolerating everything", "shared", newPod(nil, corev1.Toleration{Operator: corev1.TolerationOpExists}), []string{"team-a", "team-b"}},
+	}
+	for _, fixture := range fixtures {
+		t.Run(fixture.name, func(t *testing.T) {
+			violations := handler.validate(fixture.namespace, namespaces, fixture.pod)
+			if len(violations) != len(fixture.violations) {
+				t.Fatalf("expected %v, got %v", fixture.violations, violations)
+			}
+			for i, violation := range fixture.violations {
+				if !strings.Contains(violations[i], violation) {
+					t.Errorf("expected %s, got %s", violation, violations[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateTenancyInherited(t *testing.T) {
+	handler := &tenancyHandler{TenancyConfig: tenancyConfig}
+	handler.ParentLabel = "parent"
+	namespaces := []corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Annotations: map[string]string{"tolerations": "platform.flanksource.com/node-group=a"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-a-dev", Labels: map[string]string{"parent": "team-a"}}},
+	}
+	groupA := corev1.Toleration{Key: "platform.flanksource.com/node-group", Operator: corev1.TolerationOpEqual, Value: "a", Effect: corev1.TaintEffectNoSchedule}
+
+	bound := &corev1.Pod{Spec: corev1.PodSpec{NodeSelector: map[string]string{"platform.flanksource.com/node-group": "a"}, Tolerations: []corev1.Toleration{groupA}}}
+	if violations := handler.validate("team-a-dev", namespaces, bound); len(violations) != 0 {
+		t.Errorf("expected the pod to be bound to the inherited node group, got %v", violations)
+	}
+	unbound := &corev1.Pod{}
+	if violations := handler.validate("team-a-dev", namespaces, unbound); len(violations) != 2 {
+		t.Errorf("expected missing toleration and node selector, got %v", violations)
+	}
+}
+
+func TestValidateTenancyDefaultTolerations(t *testing.T) {
+	handler := &tenancyHandler{TenancyConfig: tenancyConfig}
+	namespaces := []corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Annotations: map[string]string{"tolerations": "node.kubernetes.io/unreachable:NoExecute=300;spot=true"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-b", Annotations: map[string]string{"tolerations": "dedicated=b;node-role.kubernetes.io/master:NoSchedule"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "shared"}},
+	}
+	seconds := int64(300)
+	// the tolerations the DefaultTolerationSeconds admission plugin adds to every pod
+	defaults := []corev1.Toleration{
+		{Key: "node.kubernetes.io/not-ready", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute, TolerationSeconds: &seconds},
+		{Key: "node.kubernetes.io/unreachable", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute, TolerationSeconds: &seconds},
+	}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Tolerations: defaults}}
+
+	for _, namespace := range []string{"team-a", "shared"} {
+		if violations := handler.validate(namespace, namespaces, pod); len(violations) != 0 {
+			t.Errorf("expected well-known and unconfigured taints not to be node groups in %s, got %v", namespace, violations)
+		}
+	}
+	if violations := handler.validate("team-b", namespaces, pod); len(violations) != 2 || !strings.Contains(violations[0], "dedicated=b") {
+		t.Errorf("expected only the dedicated node group to be validated, got %v", violations)
+	}
+}
+
+func TestTenancyHandleDisabled(t *testing.T) {
+	handler := &tenancyHandler{TenancyConfig: tenancyConfig}
+	handler.Enabled = false
+	request := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Operation: admissionv1.Create, Namespace: "team-a"}}
+	if response := handler.Handle(context.Background(), request); !response.Allowed {
+		t.Errorf("expected all pods to be allowed when node group tenancy is disabled, got %v", response.Result)
+	}
+}
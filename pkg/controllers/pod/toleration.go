@@ -0,0 +1,130 @@
+package pod
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// ParseTolerations parses the namespace tolerations annotation, which is either a list of tolerations in JSON or YAML,
+// or ; separated tolerations using the kubectl taint syntax:
+//
+//	key=value:Effect      tolerates the taint key=value with the effect
+//	key:Effect            tolerates the taint key with any value
+//	key:NoExecute=300     tolerates the taint key for 300 seconds after it is added
+//
+// The effect defaults to NoSchedule when omitted. Invalid entries are returned as errors and skipped.
+func ParseTolerations(value string) ([]corev1.Toleration, []error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "[") || strings.HasPrefix(value, "-") {
+		tolerations := []corev1.Toleration{}
+		if err := yaml.Unmarshal([]byte(value), &tolerations); err != nil {
+			return nil, []error{errors.Wrap(err, "invalid list of tolerations")}
+		}
+		valid, errs := []corev1.Toleration{}, []error{}
+		for _, toleration := range tolerations {
+			if err := validateToleration(toleration); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			valid = append(valid, toleration)
+		}
+		return valid, errs
+	}
+
+	tolerations, errs := []corev1.Toleration{}, []error{}
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		toleration, err := parseToleration(entry)
+		if err == nil {
+			err = validateToleration(toleration)
+		}
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "invalid toleration %q", entry))
+			continue
+		}
+		tolerations = append(tolerations, toleration)
+	}
+	return tolerations, errs
+}
+
+func parseToleration(entry string) (corev1.Toleration, error) {
+	toleration := corev1.Toleration{Effect: corev1.TaintEffectNoSchedule}
+	taint := entry
+	if i := strings.LastIndex(entry, ":"); i >= 0 {
+		taint = entry[:i]
+		effect := entry[i+1:]
+		if parts := strings.SplitN(effect, "=", 2); len(parts) == 2 {
+			seconds, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil || seconds < 0 {
+				return toleration, errors.Errorf("invalid toleration seconds %s", parts[1])
+			}
+			toleration.TolerationSeconds = &seconds
+			effect = parts[0]
+		}
+		toleration.Effect = corev1.TaintEffect(effect)
+	}
+	if parts := strings.SplitN(taint, "=", 2); len(parts) == 2 {
+		toleration.Key, toleration.Value, toleration.Operator = parts[0], parts[1], corev1.TolerationOpEqual
+	} else {
+		toleration.Key, toleration.Operator = taint, corev1.TolerationOpExists
+	}
+	return toleration, nil
+}
+
+func validateToleration(toleration corev1.Toleration) error {
+	if toleration.Key != "" {
+		if errs := validation.IsQualifiedName(toleration.Key); len(errs) > 0 {
+			return errors.Errorf("invalid key %s: %s", toleration.Key, strings.Join(errs, ", "))
+		}
+	}
+	switch toleration.Operator {
+	case corev1.TolerationOpEqual, "":
+		if toleration.Key == "" {
+			return errors.New("a key is required with the Equal operator")
+		}
+		if errs := validation.IsValidLabelValue(toleration.Value); len(errs) > 0 {
+			return errors.Errorf("invalid value %s: %s", toleration.Value, strings.Join(errs, ", "))
+		}
+	case corev1.TolerationOpExists:
+		if toleration.Value != "" {
+			return errors.New("a value cannot be set with the Exists operator")
+		}
+	default:
+		return errors.Errorf("unsupported operator %s", toleration.Operator)
+	}
+	switch toleration.Effect {
+	case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute, "":
+	default:
+		return errors.Errorf("unsupported effect %s", toleration.Effect)
+	}
+	if toleration.TolerationSeconds != nil && toleration.Effect != corev1.TaintEffectNoExecute {
+		return errors.New("toleration seconds are only supported with the NoExecute effect")
+	}
+	return nil
+}
+
+func hasToleration(tolerations []corev1.Toleration, toleration corev1.Toleration) bool {
+	for _, existing := range tolerations {
+		if equality.Semantic.DeepEqual(normalizeToleration(existing), normalizeToleration(toleration)) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeToleration applies the defaults of the API server, the operator defaults to Equal
+func normalizeToleration(toleration corev1.Toleration) corev1.Toleration {
+	if toleration.Operator == "" {
+		toleration.Operator = corev1.TolerationOpEqual
+	}
+	return toleration
+}
@@ -0,0 +1,79 @@
+package pod
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestParseTolerations(t *testing.T) {
+	seconds := int64(300)
+	fixtures := map[string][]corev1.Toleration{
+		"node.kubernetes.io/group=instrumented": {
+			{Key: "node.kubernetes.io/group", Operator: corev1.TolerationOpEqual, Value: "instrumented", Effect: corev1.TaintEffectNoSchedule},
+		},
+		"group=gpu:PreferNoSchedule; dedicated:NoSchedule": {
+			{Key: "group", Operator: corev1.TolerationOpEqual, Value: "gpu", Effect: corev1.TaintEffectPreferNoSchedule},
+			{Key: "dedicated", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+		},
+		"node.kubernetes.io/unreachable:NoExecute=300": {
+			{Key: "node.kubernetes.io/unreachable", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute, TolerationSeconds: &seconds},
+		},
+		`[{"key": "group", "operator": "Exists"}]`: {
+			{Key: "group", Operator: corev1.TolerationOpExists},
+		},
+		"- key: group\n  value: gpu\n  effect: NoExecute\n  tolerationSeconds: 300": {
+			{Key: "group", Value: "gpu", Effect: corev1.TaintEffectNoExecute, TolerationSeconds: &seconds},
+		},
+	}
+	for value, expected := range fixtures {
+		t.Run(value, func(t *testing.T) {
+			tolerations, errs := ParseTolerations(value)
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			if !reflect.DeepEqual(tolerations, expected) {
+				t.Errorf("expected %v, got %v", expected, tolerations)
+			}
+		})
+	}
+}
+
+func TestParseInvalidTolerations(t *testing.T) {
+	for _, value := range []string{"group=gpu:Sometimes", "group:NoSchedule=300", "group:NoExecute=soon", "=gpu", "in valid", `[{"key": "group", "operator": "Exists", "value": "gpu"}]`, "[{"} {
+		t.Run(value, func(t *testing.T) {
+			if tolerations, errs := ParseTolerations(value); len(errs) != 1 || len(tolerations) != 0 {
+				t.Errorf("expected %s to be invalid, got %v %v", value, tolerations, errs)
+			}
+		})
+	}
+
+	tolerations, errs := ParseTolerations("group;group=gpu:Sometimes;dedicated=true")
+	if len(tolerations) != 2 || len(errs) != 1 {
+		t.Errorf("expected valid entries to be kept, got %v %v", tolerations, errs)
+	}
+}
+
+func TestUpdateTolerations(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	handler := &podHandler{Log: logf.Log, Recorder: recorder}
+	handler.TolerationsAnnotation = "tolerations"
+	namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "gpu", Annotations: map[string]string{
+		"tolerations": "group=gpu:NoSchedule;group=gpu:Sometimes",
+	}}}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Tolerations: []corev1.Toleration{
+		{Key: "group", Value: "gpu", Effect: corev1.TaintEffectNoSchedule},
+	}}}
+
+	pod = handler.UpdateTolerations(namespace, pod)
+	if len(pod.Spec.Tolerations) != 1 {
+		t.Errorf("expected an existing toleration not to be duplicated, got %v", pod.Spec.Tolerations)
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("expected an event for the invalid toleration, got %d", len(recorder.Events))
+	}
+}
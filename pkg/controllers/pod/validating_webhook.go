@@ -0,0 +1,197 @@
+package pod
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// ImagePolicyExemptLabel on a namespace disables the image policy for its pods and workloads
+	ImagePolicyExemptLabel = "platform.flanksource.com/image-policy-exempt"
+	// RequireImageDigestLabel on a namespace requires all images to be pinned by digest
+	RequireImageDigestLabel = "platform.flanksource.com/require-image-digest"
+)
+
+type imagePolicyHandler struct {
+	Client client.Client
+	Log    logr.Logger
+	platformv1.ImagePolicyConfig
+}
+
+//+kubebuilder:webhook:path=/validate-v1-images,mutating=false,sideEffects=None,admissionReviewVersions=v1,failurePolicy=fail,groups="";apps;batch,resources=pods;pods/ephemeralcontainers;deployments;statefulsets;daemonsets;jobs;cronjobs,verbs=create;update,versions=v1;v1beta1,name=validate-images.platform.flanksource.com
+func NewValidatingWebhook(client client.Client, cfg platformv1.ImagePolicyConfig) *admission.Webhook {
+	return &admission.Webhook{
+		Handler: &imagePolicyHandler{
+			Client:            client,
+			ImagePolicyConfig: cfg,
+			Log:               logf.Log.WithName("image-policy")},
+	}
+}
+
+var _ admission.Handler = &imagePolicyHandler{}
+
+func (handler *imagePolicyHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if !handler.Enabled {
+		return admission.Allowed("")
+	}
+	spec, err := podSpec(req.Kind.Kind, req.Object.Raw)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if spec == nil {
+		return admission.Allowed("")
+	}
+	if req.Operation == admissionv1.Update {
+		// only validate updates changing images so that existing pods and workloads can still be e.g. relabelled
+		old, err := podSpec(req.Kind.Kind, req.OldObject.Raw)
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		if reflect.DeepEqual(images(old), images(spec)) {
+			return admission.Allowed("")
+		}
+	}
+
+	namespace, err := getNamespace(ctx, handler.Client, req.Namespace)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if namespace.Labels[ImagePolicyExemptLabel] == "true" {
+		return admission.Allowed("")
+	}
+
+	violations := validateImages(handler.ImagePolicyConfig, spec, namespace.Labels[RequireImageDigestLabel] == "true")
+	if len(violations) == 0 {
+		return admission.Allowed("")
+	}
+	if handler.AuditOnly {
+		handler.Log.Info("Image policy violation", "kind", req.Kind.Kind, "namespace", req.Namespace, "name", req.Name, "violations", violations)
+		return admission.Allowed("").WithWarnings(violations...)
+	}
+	return admission.Denied(fmt.Sprintf("%s/%s/%s violates the image policy: %s", req.Kind.Kind, req.Namespace, req.Name, strings.Join(violations, "; ")))
+}
+
+// podSpec returns the pod spec of a pod or the pod template of a workload, a spec with only the ephemeral containers
+// for the ephemeralcontainers subresource, or nil for any other kind
+func podSpec(kind string, raw []byte) (*corev1.PodSpec, error) {
+	switch kind {
+	case "Pod":
+		obj := corev1.Pod{}
+		return &obj.Spec, decode(kind, raw, &obj)
+	case "EphemeralContainers":
+		// sent to the ephemeralcontainers subresource by e.g. kubectl debug
+		obj := corev1.EphemeralContainers{}
+		err := decode(kind, raw, &obj)
+		return &corev1.PodSpec{EphemeralContainers: obj.EphemeralContainers}, err
+	case "Deployment":
+		obj := appsv1.Deployment{}
+		return &obj.Spec.Template.Spec, decode(kind, raw, &obj)
+	case "StatefulSet":
+		obj := appsv1.StatefulSet{}
+		return &obj.Spec.Template.Spec, decode(kind, raw, &obj)
+	case "DaemonSet":
+		obj := appsv1.DaemonSet{}
+		return &obj.Spec.Template.Spec, decode(kind, raw, &obj)
+	case "Job":
+		obj := batchv1.Job{}
+		return &obj.Spec.Template.Spec, decode(kind, raw, &obj)
+	case "CronJob":
+		// the job template is the same in batch/v1beta1 and batch/v1
+		obj := batchv1beta1.CronJob{}
+		return &obj.Spec.JobTemplate.Spec.Template.Spec, decode(kind, raw, &obj)
+	}
+	return nil, nil
+}
+
+func decode(kind string, raw []byte, obj interface{}) error {
+	return errors.Wrapf(json.Unmarshal(raw, obj), "failed to decode %s", kind)
+}
+
+func images(spec *corev1.PodSpec) []string {
+	images := []string{}
+	for _, container := range spec.InitContainers {
+		images = append(images, container.Image)
+	}
+	for _, container := range spec.Containers {
+		images = append(images, container.Image)
+	}
+	for _, container := range spec.EphemeralContainers {
+		images = append(images, container.Image)
+	}
+	return images
+}
+
+// validateImages returns a message for every rule broken by an image of the pod spec
+func validateImages(cfg platformv1.ImagePolicyConfig, spec *corev1.PodSpec, requireDigest bool) []string {
+	violations := []string{}
+	validate := func(container, image string) {
+		for _, violation := range validateImage(cfg, image, requireDigest) {
+			violations = append(violations, fmt.Sprintf("container %s: image %s %s", container, image, violation))
+		}
+	}
+	for _, container := range spec.InitContainers {
+		validate(container.Name, container.Image)
+	}
+	for _, container := range spec.Containers {
+		validate(container.Name, container.Image)
+	}
+	for _, container := range spec.EphemeralContainers {
+		validate(container.Name, container.Image)
+	}
+	return violations
+}
+
+func validateImage(cfg platformv1.ImagePolicyConfig, image string, requireDigest bool) []string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return []string{fmt.Sprintf("is invalid: %v", err)}
+	}
+
+	violations := []string{}
+	if !isAllowedRegistry(cfg.AllowedRegistries, named.Name()) {
+		violations = append(violations, fmt.Sprintf("violates allowed-registries: %s is not an allowed registry", reference.Domain(named)))
+	}
+	tagged, isTagged := named.(reference.Tagged)
+	_, isDigested := named.(reference.Digested)
+	if isTagged && tagged.Tag() == "latest" {
+		violations = append(violations, "violates no-latest: the latest tag is not allowed")
+	} else if !isTagged && !isDigested {
+		violations = append(violations, "violates no-latest: images must have a tag")
+	}
+	if requireDigest && !isDigested {
+		violations = append(violations, "violates require-digest: images must be pinned by digest in this namespace")
+	}
+	return violations
+}
+
+// isAllowedRegistry returns true if there is no allowlist or the normalized image name is below one of its entries
+func isAllowedRegistry(allowed []string, name string) bool {
+	restricted := false
+	for _, registry := range allowed {
+		if registry == "" {
+			continue
+		}
+		restricted = true
+		if hasPathPrefix(name, registry) {
+			return true
+		}
+	}
+	return !restricted
+}
@@ -0,0 +1,81 @@
+package pod
+
+import (
+	"strings"
+	"testing"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestValidateImage(t *testing.T) {
+	cfg := platformv1.ImagePolicyConfig{AllowedRegistries: []string{"registry.corp", "quay.io/prometheus", ""}}
+	fixtures := []struct {
+		image         string
+		requireDigest bool
+		rules         []string
+	}{
+		{"registry.corp/app:1.0", false, nil},
+		{"quay.io/prometheus/prometheus:v2.0.0", false, nil},
+		{"quay.io/coreos/etcd:v3.4", false, []string{"allowed-registries"}},
+		{"nginx:1.19", false, []string{"allowed-registries"}},
+		{"registry.corp/app:latest", false, []string{"no-latest"}},
+		{"registry.corp/app", false, []string{"no-latest"}},
+		{"registry.corp/app@" + testDigest, false, nil},
+		{"registry.corp/app:1.0", true, []string{"require-digest"}},
+		{"registry.corp/app:1.0@" + testDigest, true, nil},
+		{"nginx", true, []string{"allowed-registries", "no-latest", "require-digest"}},
+		{"Invalid:Image", false, []string{"invalid"}},
+	}
+
+	for _, fixture := range fixtures {
+		t.Run(fixture.image, func(t *testing.T) {
+			violations := validateImage(cfg, fixture.image, fixture.requireDigest)
+			if len(violations) != len(fixture.rules) {
+				t.Fatalf("expected %v, got %v", fixture.rules, violations)
+			}
+			for i, rule := range fixture.rules {
+				if !strings.Contains(violations[i], rule) {
+					t.Errorf("expected %s, got %s", rule, violations[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateImagesNamesContainer(t *testing.T) {
+	spec := &corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "init", Image: "busybox:1.32"}},
+		Containers:     []corev1.Container{{Name: "app", Image: "busybox:latest"}},
+	}
+	violations := validateImages(platformv1.ImagePolicyConfig{}, spec, false)
+	if len(violations) != 1 || !strings.HasPrefix(violations[0], "container app: image busybox:latest violates no-latest") {
+		t.Errorf("unexpected violations %v", violations)
+	}
+}
+
+func TestPodSpec(t *testing.T) {
+	cronJob := `{"apiVersion":"batch/v1","kind":"CronJob","spec":{"jobTemplate":{"spec":{"template":{"spec":{"containers":[{"name":"job","image":"busybox:1.32"}]}}}}}}`
+	spec, err := podSpec("CronJob", []byte(cronJob))
+	if err != nil || len(spec.Containers) != 1 || spec.Containers[0].Image != "busybox:1.32" {
+		t.Errorf("unexpected cronjob pod spec %v %v", spec, err)
+	}
+
+	deployment := `{"apiVersion":"apps/v1","kind":"Deployment","spec":{"template":{"spec":{"containers":[{"name":"app","image":"nginx"}]}}}}`
+	spec, err = podSpec("Deployment", []byte(deployment))
+	if err != nil || spec.Containers[0].Image != "nginx" {
+		t.Errorf("unexpected deployment pod spec %v %v", spec, err)
+	}
+
+	ephemeral := `{"apiVersion":"v1","kind":"EphemeralContainers","ephemeralContainers":[{"name":"debug","image":"busybox:latest"}]}`
+	spec, err = podSpec("EphemeralContainers", []byte(ephemeral))
+	if err != nil || len(spec.EphemeralContainers) != 1 {
+		t.Errorf("unexpected ephemeral containers pod spec %v %v", spec, err)
+	} else if violations := validateImages(platformv1.ImagePolicyConfig{}, spec, false); len(violations) != 1 || !strings.HasPrefix(violations[0], "container debug:") {
+		t.Errorf("expected the ephemeral container to be validated, got %v", violations)
+	}
+
+	if spec, err := podSpec("Service", []byte(`{}`)); spec != nil || err != nil {
+		t.Errorf("expected other kinds to be ignored")
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pullsecret
+
+import (
+	"context"
+	"testing"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcile(t *testing.T) {
+	src := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "platform-system"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths": {}}`)},
+	}
+	tenant := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant", Labels: map[string]string{"tenant": "true"}}}
+	other := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(src, tenant, other).Build()
+	r, err := newReconciler(c, scheme.Scheme, platformv1.ReplicationConfig{
+		SourceNamespace: "platform-system", SourceName: "registry", NamespaceSelector: "tenant=true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	reconcileNamespace := func(name string) {
+		if _, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Name: name}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	getCopy := func(namespace string) (*corev1.Secret, error) {
+		secret := &corev1.Secret{}
+		return secret, c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "registry"}, secret)
+	}
+
+	reconcileNamespace("tenant")
+	reconcileNamespace("other")
+	replica, err := getCopy("tenant")
+	if err != nil || string(replica.Data[corev1.DockerConfigJsonKey]) != `{"auths": {}}` || replica.Annotations[ReplicatedFromAnnotation] != "platform-system/registry" {
+		t.Fatalf("expected the secret to be replicated, got %v %v", replica, err)
+	}
+	if _, err := getCopy("other"); !apierrors.IsNotFound(err) {
+		t.Errorf("expected an unselected namespace to be skipped, got %v", err)
+	}
+
+	src.Data[corev1.DockerConfigJsonKey] = []byte(`{"auths": {"registry.corp": {}}}`)
+	if err := c.Update(ctx, src); err != nil {
+		t.Fatal(err)
+	}
+	reconcileNamespace("tenant")
+	if replica, _ := getCopy("tenant"); string(replica.Data[corev1.DockerConfigJsonKey]) != `{"auths": {"registry.corp": {}}}` {
+		t.Errorf("expected the copy to be updated, got %s", replica.Data[corev1.DockerConfigJsonKey])
+	}
+
+	if err := c.Delete(ctx, replica); err != nil {
+		t.Fatal(err)
+	}
+	reconcileNamespace("tenant")
+	if _, err := getCopy("tenant"); err != nil {
+		t.Errorf("expected a deleted copy to be recreated, got %v", err)
+	}
+
+	tenant.Labels = nil
+	if err := c.Update(ctx, tenant); err != nil {
+		t.Fatal(err)
+	}
+	reconcileNamespace("tenant")
+	if _, err := getCopy("tenant"); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the copy to be removed from an unselected namespace, got %v", err)
+	}
+}
+
+func TestReconcileKeepsUnmanagedSecrets(t *testing.T) {
+	src := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "platform-system"}, Data: map[string][]byte{"a": []byte("b")}}
+	own := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "tenant"}, Data: map[string][]byte{"c": []byte("d")}}
+	tenant := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}}
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(src, own, tenant).Build()
+	r, _ := newReconciler(c, scheme.Scheme, platformv1.ReplicationConfig{SourceNamespace: "platform-system", SourceName: "registry"})
+
+	if _, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "tenant"}}); err != nil {
+		t.Fatal(err)
+	}
+	secret := &corev1.Secret{}
+	if err := c.Get(context.Background(), types.NamespacedName{Namespace: "tenant", Name: "registry"}, secret); err != nil || string(secret.Data["c"]) != "d" {
+		t.Errorf("expected an existing secret not created by the operator to be kept, got %v %v", secret.Data, err)
+	}
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package replication
+
+import (
+	"context"
+	"reflect"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// ReplicatedFromAnnotation is set on copies of the source object to <namespace>/<name> of the source
+const ReplicatedFromAnnotation = "platform.flanksource.com/replicated-from"
+
+// Kind is a kind of object replicated into namespaces
+type Kind struct {
+	// Name of the kind in log messages, e.g. secret
+	Name string
+	// Resource is the core/v1 resource of the kind, e.g. secrets
+	Resource string
+	// New returns an empty object of the kind
+	New func() client.Object
+	// Sync copies the content of src to dst, it returns whether dst changed and whether it must be recreated instead
+	// of updated as some of the content is immutable
+	Sync func(src, dst client.Object) (changed, recreate bool)
+}
+
+// NewReconciler returns a reconciler named name replicating the source object of the kind, which reads the objects of
+// the kind with the client until it is added to a manager
+func NewReconciler(c client.Client, scheme *runtime.Scheme, name string, kind Kind, cfg platformv1.ReplicationConfig) (*Reconciler, error) {
+	selector, err := labels.Parse(cfg.NamespaceSelector)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid namespace selector %s", cfg.NamespaceSelector)
+	}
+	return &Reconciler{
+		Client:   c,
+		Scheme:   scheme,
+		name:     name,
+		kind:     kind,
+		log:      logf.Log.WithName(name),
+		source:   types.NamespacedName{Namespace: cfg.SourceNamespace, Name: cfg.SourceName},
+		selector: selector,
+		get:      c.Get,
+	}, nil
+}
+
+// Add adds the reconciler to the manager, watching namespaces and the objects of its kind named like the source. The
+// objects are watched and read with an informer of their own, as the cache of the manager would hold every object of
+// the kind in the cluster, e.g. all secrets.
+func (r *Reconciler) Add(mgr manager.Manager) error {
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+	lw := toolscache.NewFilteredListWatchFromClient(clientset.CoreV1().RESTClient(), r.kind.Resource, metav1.NamespaceAll, func(options *metav1.ListOptions) {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", r.source.Name).String()
+	})
+	informer := toolscache.NewSharedIndexInformer(lw, r.kind.New(), 0, toolscache.Indexers{})
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		informer.Run(ctx.Done())
+		return nil
+	})); err != nil {
+		return err
+	}
+	r.get = r.storeGetter(informer)
+
+	c, err := controller.New(r.name, mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	if err := c.Watch(&source.Kind{Type: &corev1.Namespace{}}, &handler.EnqueueRequestForObject{}); err != nil {
+		return err
+	}
+
+	// changes to the source are replicated to all namespaces, changes to or deletion of a copy only to its own
+	fn := handler.EnqueueRequestsFromMapFunc(func(object client.Object) []reconcile.Request {
+		if object.GetNamespace() == r.source.Namespace && object.GetName() == r.source.Name {
+			return r.allNamespaces()
+		}
+		if object.GetName() == r.source.Name {
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: object.GetNamespace()}}}
+		}
+		return nil
+	})
+	return c.Watch(&source.Informer{Informer: informer}, fn)
+}
+
+// storeGetter returns a get reading objects from the store of the informer, it fails until the informer has synced so
+// that copies are not deleted for a source that has not been listed yet
+func (r *Reconciler) storeGetter(informer toolscache.SharedIndexInformer) func(context.Context, types.NamespacedName, client.Object) error {
+	return func(ctx context.Context, key types.NamespacedName, obj client.Object) error {
+		if !informer.HasSynced() {
+			return errors.Errorf("%s informer has not synced", r.kind.Resource)
+		}
+		item, exists, err := informer.GetStore().GetByKey(key.Namespace + "/" + key.Name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return apierrors.NewNotFound(corev1.Resource(r.kind.Resource), key.Name)
+		}
+		reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(item.(runtime.Object).DeepCopyObject()).Elem())
+		return nil
+	}
+}
+
+var _ reconcile.Reconciler = &Reconciler{}
+
+// Reconciler replicates the source object into every namespace matching the selector, the reconcile key is the name of
+// the namespace
+type Reconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	name     string
+	kind     Kind
+	log      logr.Logger
+	source   types.NamespacedName
+	selector labels.Selector
+	// get reads the objects of the kind
+	get func(ctx context.Context, key types.NamespacedName, obj client.Object) error
+}
+
+func (r *Reconciler) allNamespaces() []reconcile.Request {
+	namespaces := corev1.NamespaceList{}
+	if err := r.List(context.Background(), &namespaces); err != nil {
+		r.log.Error(err, "Failed to list namespaces")
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, namespace := range namespaces.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: namespace.Name}})
+	}
+	return requests
+}
+
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+func (r *Reconciler) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	if request.Name == r.source.Namespace {
+		return reconcile.Result{}, nil
+	}
+	namespace := corev1.Namespace{}
+	if err := r.Get(ctx, request.NamespacedName, &namespace); err != nil {
+		if apierrors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	if namespace.Status.Phase == corev1.NamespaceTerminating {
+		return reconcile.Result{}, nil
+	}
+
+	existing := r.kind.New()
+	err := r.get(ctx, types.NamespacedName{Namespace: namespace.Name, Name: r.source.Name}, existing)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return reconcile.Result{}, err
+	}
+	exists := err == nil
+	if exists && existing.GetAnnotations()[ReplicatedFromAnnotation] != r.source.String() {
+		r.log.Info("Not replacing existing "+r.kind.Name, "namespace", namespace.Name, "name", r.source.Name)
+		return reconcile.Result{}, nil
+	}
+
+	src := r.kind.New()
+	err = r.get(ctx, r.source, src)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return reconcile.Result{}, err
+	}
+	if apierrors.IsNotFound(err) || !r.selector.Matches(labels.Set(namespace.Labels)) {
+		if exists {
+			r.log.Info("Deleting replicated "+r.kind.Name, "namespace", namespace.Name, "name", r.source.Name)
+			if err := r.Delete(ctx, existing); err != nil && !apierrors.IsNotFound(err) {
+				return reconcile.Result{}, err
+			}
+		}
+		return reconcile.Result{}, nil
+	}
+
+	if !exists {
+		r.log.Info("Replicating "+r.kind.Name, "namespace", namespace.Name, "name", r.source.Name)
+		return reconcile.Result{}, r.Create(ctx, r.replicate(src, namespace.Name))
+	}
+	changed, recreate := r.kind.Sync(src, existing)
+	if !changed {
+		return reconcile.Result{}, nil
+	}
+	if recreate {
+		if err := r.Delete(ctx, existing); err != nil && !apierrors.IsNotFound(err) {
+			return reconcile.Result{}, err
+		}
+		return reconcile.Result{}, r.Create(ctx, r.replicate(src, namespace.Name))
+	}
+	r.log.Info("Updating replicated "+r.kind.Name, "namespace", namespace.Name, "name", r.source.Name)
+	return reconcile.Result{}, r.Update(ctx, existing)
+}
+
+func (r *Reconciler) replicate(src client.Object, namespace string) client.Object {
+	dst := r.kind.New()
+	dst.SetName(src.GetName())
+	dst.SetNamespace(namespace)
+	dst.SetAnnotations(map[string]string{ReplicatedFromAnnotation: src.GetNamespace() + "/" + src.GetName()})
+	r.kind.Sync(src, dst)
+	return dst
+}
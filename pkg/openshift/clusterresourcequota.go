@@ -0,0 +1,169 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package openshift converts OpenShift quota.openshift.io/v1 ClusterResourceQuotas to platform.flanksource.com/v1
+// +kubebuilder:skip
+package openshift
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	APIVersion = "quota.openshift.io/v1"
+	Kind       = "ClusterResourceQuota"
+
+	lastAppliedConfigAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+	openshiftAnnotationPrefix   = "openshift.io/"
+)
+
+// ClusterResourceQuota mirrors the fields of quota.openshift.io/v1 ClusterResourceQuota that are converted
+type ClusterResourceQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              ClusterResourceQuotaSpec `json:"spec"`
+}
+
+type ClusterResourceQuotaSpec struct {
+	Selector ClusterResourceQuotaSelector `json:"selector"`
+	Quota    corev1.ResourceQuotaSpec     `json:"quota"`
+}
+
+// ClusterResourceQuotaSelector selects namespaces by labels and/or annotations, both must match
+type ClusterResourceQuotaSelector struct {
+	Labels      *metav1.LabelSelector `json:"labels"`
+	Annotations map[string]string     `json:"annotations"`
+}
+
+// UnsupportedError lists the selector features of a quota that cannot be expressed in platform.flanksource.com/v1
+type UnsupportedError struct {
+	Name     string
+	Features []string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("ClusterResourceQuota/%s cannot be converted: %s", e.Name, strings.Join(e.Features, "; "))
+}
+
+// ToPlatform converts an OpenShift quota, returning an *UnsupportedError if the selector cannot be mapped
+// exactly: dropping part of a selector would apply the quota to more namespaces than intended. The last applied
+// configuration of kubectl and the openshift.io/ annotations are not copied.
+func ToPlatform(in *ClusterResourceQuota) (*platformv1.ClusterResourceQuota, error) {
+	unsupported := []string{}
+	selector := in.Spec.Selector
+
+	if len(selector.Annotations) > 0 {
+		unsupported = append(unsupported, fmt.Sprintf("spec.selector.annotations %s", formatMap(selector.Annotations)))
+	}
+
+	out := &platformv1.ClusterResourceQuota{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: platformv1.GroupVersion.String(),
+			Kind:       Kind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        in.Name,
+			Labels:      in.Labels,
+			Annotations: convertedAnnotations(in.Annotations),
+		},
+		Spec: platformv1.ClusterResourceQuotaSpec{
+			ResourceQuotaSpec: *in.Spec.Quota.DeepCopy(),
+		},
+	}
+
+	switch {
+	case selector.Labels == nil && len(selector.Annotations) == 0:
+		unsupported = append(unsupported, "spec.selector is empty")
+	case selector.Labels == nil:
+		// annotations only, already reported
+	case len(selector.Labels.MatchLabels) == 0 && len(selector.Labels.MatchExpressions) == 0:
+		// an empty label selector matches every namespace
+		out.Spec.AllNamespaces = true
+	default:
+		for _, expr := range selector.Labels.MatchExpressions {
+			unsupported = append(unsupported, fmt.Sprintf("spec.selector.labels.matchExpressions %s %s %v", expr.Key, expr.Operator, expr.Values))
+		}
+		if len(selector.Labels.MatchLabels) > 0 {
+			out.Spec.MatchLabels = map[string]string{}
+			for k, v := range selector.Labels.MatchLabels {
+				out.Spec.MatchLabels[k] = v
+			}
+		}
+	}
+
+	if len(unsupported) > 0 {
+		return nil, &UnsupportedError{Name: in.Name, Features: unsupported}
+	}
+	return out, nil
+}
+
+// FromPlatform converts a platform.flanksource.com/v1 quota back to OpenShift
+func FromPlatform(in *platformv1.ClusterResourceQuota) *ClusterResourceQuota {
+	out := &ClusterResourceQuota{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: APIVersion,
+			Kind:       Kind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        in.Name,
+			Labels:      in.Labels,
+			Annotations: in.Annotations,
+		},
+		Spec: ClusterResourceQuotaSpec{
+			Quota: *in.Spec.ResourceQuotaSpec.DeepCopy(),
+		},
+	}
+	if in.Spec.AllNamespaces {
+		out.Spec.Selector.Labels = &metav1.LabelSelector{}
+	} else if len(in.Spec.MatchLabels) > 0 {
+		out.Spec.Selector.Labels = &metav1.LabelSelector{MatchLabels: map[string]string{}}
+		for k, v := range in.Spec.MatchLabels {
+			out.Spec.Selector.Labels.MatchLabels[k] = v
+		}
+	}
+	return out
+}
+
+// convertedAnnotations returns the annotations without those only meaningful to the OpenShift object
+func convertedAnnotations(annotations map[string]string) map[string]string {
+	var out map[string]string
+	for k, v := range annotations {
+		if k == lastAppliedConfigAnnotation || strings.HasPrefix(k, openshiftAnnotationPrefix) {
+			continue
+		}
+		if out == nil {
+			out = map[string]string{}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func formatMap(m map[string]string) string {
+	pairs := []string{}
+	for k, v := range m {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openshift
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newQuota(selector ClusterResourceQuotaSelector) *ClusterResourceQuota {
+	return &ClusterResourceQuota{
+		TypeMeta:   metav1.TypeMeta{APIVersion: APIVersion, Kind: Kind},
+		ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"owner": "finance"}},
+		Spec: ClusterResourceQuotaSpec{
+			Selector: selector,
+			Quota: corev1.ResourceQuotaSpec{
+				Hard:   corev1.ResourceList{corev1.ResourcePods: resource.MustParse("10")},
+				Scopes: []corev1.ResourceQuotaScope{corev1.ResourceQuotaScopeNotBestEffort},
+			},
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	fixtures := map[string]ClusterResourceQuotaSelector{
+		"match labels":   {Labels: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}},
+		"all namespaces": {Labels: &metav1.LabelSelector{}},
+	}
+
+	for name, selector := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			in := newQuota(selector)
+			converted, err := ToPlatform(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := FromPlatform(converted)
+			if !reflect.DeepEqual(in, out) {
+				t.Errorf("round trip changed the quota:\n%+v\n%+v", in, out)
+			}
+		})
+	}
+}
+
+func TestUnsupportedSelectors(t *testing.T) {
+	fixtures := map[string]ClusterResourceQuotaSelector{
+		"annotations": {Annotations: map[string]string{"openshift.io/requester": "alice"}},
+		"expressions": {Labels: &metav1.LabelSelector{
+			MatchLabels:      map[string]string{"team": "a"},
+			MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "env", Operator: metav1.LabelSelectorOpIn, Values: []string{"dev"}}},
+		}},
+		"empty": {},
+	}
+
+	for name, selector := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			_, err := ToPlatform(newQuota(selector))
+			if _, ok := err.(*UnsupportedError); !ok {
+				t.Errorf("expected an UnsupportedError, got %v", err)
+			}
+		})
+	}
+}
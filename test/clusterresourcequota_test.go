@@ -134,5 +134,13 @@ var _ = Describe("ClusterResourceQuota Controller", func() {
 			_, err = CreateQuota(n2.Name, "1500m", "1Gi")
 			Expect(err).To(HaveOccurred())
 		})
+
+		It("should not allow a ClusterResourceQuota without a selector", func() {
+			invalid := newClusterResourceQuota()
+			invalid.Spec.MatchLabels = nil
+			err := k8sClient.Create(ctx, invalid)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.matchLabels"))
+		})
 	})
 })
@@ -14,6 +14,7 @@ import (
 
 	"github.com/flanksource/commons/certs"
 	platformv1 "github.com/flanksource/platform-operator/pkg/apis/platform/v1"
+	platformv2 "github.com/flanksource/platform-operator/pkg/apis/platform/v2"
 	"github.com/flanksource/platform-operator/pkg/controllers/cleanup"
 	"github.com/flanksource/platform-operator/pkg/controllers/clusterresourcequota"
 	"github.com/flanksource/platform-operator/pkg/controllers/pod"
@@ -21,6 +22,8 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gexec"
 	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -31,6 +34,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/conversion"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -118,6 +122,29 @@ func registerWebhook(manager ctrl.Manager, name string, webhook *admission.Webho
 
 }
 
+func registerConversionWebhook(manager ctrl.Manager, crdName string) error {
+	path := "/convert"
+	manager.GetWebhookServer().Register(path, &conversion.Webhook{})
+
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := manager.GetAPIReader().Get(context.TODO(), types.NamespacedName{Name: crdName}, crd); err != nil {
+		return err
+	}
+	caBundle, _ := ioutil.ReadFile("tls.crt")
+	urlStr := fmt.Sprintf("https://localhost:%d%s", port, path)
+	crd.Spec.Conversion = &apiextensionsv1.CustomResourceConversion{
+		Strategy: apiextensionsv1.WebhookConverter,
+		Webhook: &apiextensionsv1.WebhookConversion{
+			ClientConfig: &apiextensionsv1.WebhookClientConfig{
+				CABundle: caBundle,
+				URL:      &urlStr,
+			},
+			ConversionReviewVersions: []string{"v1"},
+		},
+	}
+	return manager.GetClient().Update(context.TODO(), crd)
+}
+
 var _ = BeforeSuite(func(done Done) {
 	logf.SetLogger(zap.New(zap.UseDevMode(true), zap.WriteTo(GinkgoWriter)))
 
@@ -137,6 +164,10 @@ var _ = BeforeSuite(func(done Done) {
 	Expect(err).NotTo(HaveOccurred())
 	err = platformv1.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
+	err = platformv2.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
+	err = apiextensionsv1.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
 
 	cert := certs.NewCertificateBuilder("localhost").Certificate
 	cert, _ = cert.SignCertificate(cert, 1)
@@ -166,7 +197,8 @@ var _ = BeforeSuite(func(done Done) {
 		TolerationsAnnotation:  "tolerations",
 		DefaultImagePullSecret: "registry-secret",
 	}
-	err = pod.Add(k8sManager, 5*time.Second, podConfig)
+	hierarchy := pod.NewNamespaceHierarchy(k8sManager.GetClient(), "", podConfig)
+	err = pod.Add(k8sManager, 5*time.Second, podConfig, hierarchy)
 	Expect(err).ToNot(HaveOccurred())
 
 	go func() {
@@ -181,7 +213,7 @@ var _ = BeforeSuite(func(done Done) {
 	By("Waiting for webhook server to come up")
 	waitFor(fmt.Sprintf("localhost:%d", port))
 	err = registerWebhook(k8sManager, "annotate-pods-v1.platform.flanksource.com",
-		&webhook.Admission{Handler: pod.NewMutatingWebhook(k8sManager.GetClient(), podConfig)},
+		&webhook.Admission{Handler: pod.NewMutatingWebhook(k8sManager.GetClient(), k8sManager.GetAPIReader(), k8sManager.GetEventRecorderFor("pod-mutator"), podConfig, hierarchy)},
 		"", "v1", "pods")
 	Expect(err).ToNot(HaveOccurred())
 
@@ -194,6 +226,8 @@ var _ = BeforeSuite(func(done Done) {
 		&webhook.Admission{Handler: clusterresourcequota.NewResourceQuotaValidatingWebhook(k8sManager.GetClient(), &sync.Mutex{}, true)},
 		"", "v1", "resourcequotas")
 	Expect(err).ToNot(HaveOccurred())
+	err = registerConversionWebhook(k8sManager, "clusterresourcequotas.platform.flanksource.com")
+	Expect(err).ToNot(HaveOccurred())
 	By("Webhook server is up")
 	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
 	Expect(err).ToNot(HaveOccurred())